@@ -0,0 +1,90 @@
+// Package apiauth authenticates the callers of the secretpaths API and assigns them a role.
+package apiauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role decides which endpoints a caller may use, every role may use the endpoints of the roles below it.
+type Role int
+
+const (
+	// None may only use the public endpoints, e.g. the health check.
+	None Role = iota
+	// Viewer may browse the paths and the graph of the secrets.
+	Viewer
+	// Auditor may also read the policies, which secrets they grant access to, and export the access graph.
+	Auditor
+	// Admin may also crawl Vault on demand.
+	Admin
+)
+
+var roleNames = []string{"none", "viewer", "auditor", "admin"}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	for role, candidate := range roleNames {
+		if candidate == name {
+			return Role(role), nil
+		}
+	}
+	return None, fmt.Errorf("unknown role %q, must be one of %s", name, strings.Join(roleNames, ", "))
+}
+
+func (r Role) String() string {
+	if r < None || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// ErrInvalidCredentials is returned if a request carries credentials which are unknown, expired or forged.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrUnavailable is returned if credentials cannot be checked, e.g. because the identity provider is down.
+var ErrUnavailable = errors.New("authentication unavailable")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name string `json:"name"`
+	// Method is the kind of credentials the caller used, e.g. api_key.
+	Method string `json:"method"`
+	Role   Role   `json:"role"`
+}
+
+// Authenticator checks one kind of credentials.
+type Authenticator interface {
+	// Authenticate returns the caller of the request. It returns false if the request does not carry
+	// the credentials it checks, and an error wrapping ErrInvalidCredentials if they are not valid
+	// or ErrUnavailable if they cannot be checked right now.
+	Authenticate(r *http.Request) (Principal, bool, error)
+}
+
+// Chain tries the authenticators in order, the first one which finds its credentials decides.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Principal, bool, error) {
+	for _, authenticator := range c {
+		principal, ok, err := authenticator.Authenticate(r)
+		if ok || err != nil {
+			return principal, ok, err
+		}
+	}
+	return Principal{}, false, nil
+}
+
+// highest returns the highest role mapped to one of the names.
+func highest(roles map[string]Role, names []string) Role {
+	role := None
+	for _, name := range names {
+		role = max(role, roles[name])
+	}
+	return role
+}
@@ -0,0 +1,77 @@
+package apiauth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"secretpaths/apiauth"
+	"testing"
+)
+
+func request(headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/v1/paths", nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []apiauth.Role{apiauth.None, apiauth.Viewer, apiauth.Auditor, apiauth.Admin} {
+		parsed, err := apiauth.ParseRole(role.String())
+		if err != nil || parsed != role {
+			t.Errorf("expected: %v, got: %v, %v", role, parsed, err)
+		}
+	}
+	if _, err := apiauth.ParseRole("owner"); err == nil {
+		t.Error("expected an unknown role to be rejected")
+	}
+	if !(apiauth.Viewer < apiauth.Auditor && apiauth.Auditor < apiauth.Admin) {
+		t.Error("expected the roles to be ordered")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys := apiauth.NewAPIKeys([]apiauth.APIKey{
+		{Name: "ci", Key: "ci-key-0123456789", Role: apiauth.Admin},
+		{Name: "dashboard", Key: "dashboard-key-0123", Role: apiauth.Viewer},
+	})
+
+	principal, ok, err := keys.Authenticate(request(map[string]string{apiauth.APIKeyHeader: "dashboard-key-0123"}))
+	if !ok || err != nil || principal.Name != "dashboard" || principal.Role != apiauth.Viewer {
+		t.Errorf("expected the dashboard key, got %+v, %v, %v", principal, ok, err)
+	}
+	if _, ok, err := keys.Authenticate(request(map[string]string{apiauth.APIKeyHeader: "ci-key"})); !ok || !errors.Is(err, apiauth.ErrInvalidCredentials) {
+		t.Errorf("expected an unknown key to be rejected, got %v, %v", ok, err)
+	}
+	if _, ok, err := keys.Authenticate(request(nil)); ok || err != nil {
+		t.Errorf("expected a request without a key to be skipped, got %v, %v", ok, err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain := apiauth.Chain{
+		apiauth.NewAPIKeys([]apiauth.APIKey{{Name: "ci", Key: "ci-key-0123456789", Role: apiauth.Admin}}),
+		apiauth.VaultTokens{
+			Lookup: func(ctx context.Context, token string) (string, []string, error) {
+				if token != "s.alice" {
+					return "", nil, apiauth.ErrInvalidCredentials
+				}
+				return "alice", []string{"default", "auditors"}, nil
+			},
+			Roles: map[string]apiauth.Role{"default": apiauth.Viewer, "auditors": apiauth.Auditor},
+		},
+	}
+
+	principal, ok, err := chain.Authenticate(request(map[string]string{apiauth.VaultTokenHeader: "s.alice"}))
+	if !ok || err != nil || principal.Name != "alice" || principal.Role != apiauth.Auditor || principal.Method != "vault" {
+		t.Errorf("expected alice to be an auditor, got %+v, %v, %v", principal, ok, err)
+	}
+	if _, _, err := chain.Authenticate(request(map[string]string{apiauth.VaultTokenHeader: "s.mallory"})); !errors.Is(err, apiauth.ErrInvalidCredentials) {
+		t.Errorf("expected an invalid token to be rejected, got %v", err)
+	}
+	if _, ok, err := chain.Authenticate(request(nil)); ok || err != nil {
+		t.Errorf("expected an anonymous request, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,55 @@
+package apiauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// APIKeyHeader carries a static API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKey is a static key, e.g. for a CI job or a dashboard.
+type APIKey struct {
+	Name string
+	Key  string
+	Role Role
+}
+
+// APIKeys authenticates requests with one of the configured keys in the X-API-Key header.
+type APIKeys struct {
+	keys []hashedKey
+}
+
+type hashedKey struct {
+	APIKey
+	hash [sha256.Size]byte
+}
+
+func NewAPIKeys(keys []APIKey) APIKeys {
+	hashed := make([]hashedKey, 0, len(keys))
+	for _, key := range keys {
+		hashed = append(hashed, hashedKey{APIKey: key, hash: sha256.Sum256([]byte(key.Key))})
+	}
+	return APIKeys{keys: hashed}
+}
+
+func (a APIKeys) Authenticate(r *http.Request) (Principal, bool, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return Principal{}, false, nil
+	}
+	// compare the hashes of every key in constant time, so the time taken does not reveal a matching prefix
+	hash := sha256.Sum256([]byte(key))
+	var found *hashedKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], a.keys[i].hash[:]) == 1 {
+			found = &a.keys[i]
+		}
+	}
+	if found == nil {
+		return Principal{}, true, fmt.Errorf("%w: unknown api key", ErrInvalidCredentials)
+	}
+	return Principal{Name: found.Name, Method: "api_key", Role: found.Role}, true, nil
+}
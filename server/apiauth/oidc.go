@@ -0,0 +1,147 @@
+package apiauth
+
+import (
+	"context"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leeway tolerates clocks which are slightly out of sync with the identity provider.
+const leeway = time.Minute
+
+// refreshInterval limits how often the discovery document is fetched again after it could not be.
+const refreshInterval = time.Minute
+
+// signingAlgorithms are the algorithms accepted for the signatures of tokens, the keys are always the public ones
+// of the provider.
+var signingAlgorithms = []string{
+	oidc.RS256, oidc.RS384, oidc.RS512,
+	oidc.PS256, oidc.PS384, oidc.PS512,
+	oidc.ES256, oidc.ES384, oidc.ES512,
+}
+
+// OIDC authenticates requests with an ID or access token of an OpenID Connect provider in the
+// Authorization header. Tokens are verified with the keys the provider publishes, the role is
+// the highest one mapped to the values of the roles claim, e.g. the groups of the caller.
+type OIDC struct {
+	Issuer   string
+	Audience string
+	// RolesClaim names the claim whose values are mapped to roles.
+	RolesClaim string
+	Roles      map[string]Role
+	client     *http.Client
+	now        func() time.Time
+
+	// mu guards the verifier, which is created once the provider was discovered. Neither the discovery nor
+	// fetching the keys holds it, the verifier fetches the keys again on its own once a token is signed with a new one.
+	mu       sync.Mutex
+	verifier *oidc.IDTokenVerifier
+	failed   time.Time
+	failure  error
+}
+
+func NewOIDC(issuer, audience, rolesClaim string, roles map[string]Role, client *http.Client) *OIDC {
+	return &OIDC{
+		Issuer:     strings.TrimSuffix(issuer, "/"),
+		Audience:   audience,
+		RolesClaim: rolesClaim,
+		Roles:      roles,
+		client:     client,
+		now:        time.Now,
+	}
+}
+
+type claims struct {
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+}
+
+func (o *OIDC) Authenticate(r *http.Request) (Principal, bool, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return Principal{}, false, nil
+	}
+	scheme, rawToken, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || rawToken == "" {
+		return Principal{}, true, fmt.Errorf("%w: expected a bearer token", ErrInvalidCredentials)
+	}
+	verifier, err := o.tokenVerifier(r.Context())
+	if err != nil {
+		// the token may well be valid, the caller should retry rather than log in again
+		return Principal{}, true, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	token, err := verifier.Verify(r.Context(), rawToken)
+	if err != nil {
+		return Principal{}, true, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	var tokenClaims claims
+	var all map[string]any
+	if err := token.Claims(&tokenClaims); err != nil {
+		return Principal{}, true, fmt.Errorf("%w: invalid claims: %w", ErrInvalidCredentials, err)
+	}
+	_ = token.Claims(&all)
+	name := tokenClaims.PreferredUsername
+	if name == "" {
+		name = tokenClaims.Email
+	}
+	if name == "" {
+		name = token.Subject
+	}
+	return Principal{Name: name, Method: "oidc", Role: highest(o.Roles, claimValues(all[o.RolesClaim]))}, true, nil
+}
+
+// tokenVerifier returns the verifier of the tokens of the issuer, the provider is discovered on first use.
+// Concurrent first requests may discover it more than once, which is cheaper than making them wait on each other.
+func (o *OIDC) tokenVerifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	verifier, failed, failure := o.verifier, o.failed, o.failure
+	o.mu.Unlock()
+	if verifier != nil {
+		return verifier, nil
+	}
+	if o.now().Sub(failed) < refreshInterval {
+		return nil, failure
+	}
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, o.client), o.Issuer)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("could not discover the keys of %s: %w", o.Issuer, err)
+		if ctx.Err() == nil {
+			// a caller who went away says nothing about the provider
+			o.failed, o.failure = o.now(), err
+		}
+		return nil, err
+	}
+	if o.verifier == nil {
+		o.verifier = provider.Verifier(&oidc.Config{
+			ClientID:             o.Audience,
+			SupportedSigningAlgs: signingAlgorithms,
+			Now: func() time.Time {
+				return o.now().Add(-leeway)
+			},
+		})
+	}
+	return o.verifier, nil
+}
+
+// claimValues returns the values of a claim which is a single string or a list of strings.
+func claimValues(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
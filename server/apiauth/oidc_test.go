@@ -0,0 +1,138 @@
+package apiauth_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"secretpaths/apiauth"
+	"testing"
+	"time"
+)
+
+type provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+// newProvider serves the discovery document and the keys of an identity provider.
+func newProvider(t *testing.T) provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "signing",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	return provider{Server: server, key: key}
+}
+
+func (p provider) sign(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	encode := func(value any) string {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": "signing", "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newProvider(t)
+	oidc := apiauth.NewOIDC(idp.URL, "secretpaths", "groups", map[string]apiauth.Role{
+		"developers": apiauth.Viewer,
+		"security":   apiauth.Auditor,
+	}, idp.Client())
+	claims := func(changes map[string]any) map[string]any {
+		valid := map[string]any{
+			"iss":                idp.URL,
+			"aud":                []string{"secretpaths", "other"},
+			"sub":                "1234",
+			"preferred_username": "alice",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"groups":             []string{"developers", "security"},
+		}
+		for key, value := range changes {
+			valid[key] = value
+		}
+		return valid
+	}
+	authenticate := func(token string) (apiauth.Principal, error) {
+		principal, ok, err := oidc.Authenticate(request(map[string]string{"Authorization": "Bearer " + token}))
+		if !ok {
+			t.Fatal("expected the bearer token to be checked")
+		}
+		return principal, err
+	}
+
+	principal, err := authenticate(idp.sign(t, idp.key, claims(nil)))
+	if err != nil || principal.Name != "alice" || principal.Role != apiauth.Auditor {
+		t.Errorf("expected alice to be an auditor, got %+v, %v", principal, err)
+	}
+	principal, err = authenticate(idp.sign(t, idp.key, claims(map[string]any{"groups": "marketing", "aud": "secretpaths"})))
+	if err != nil || principal.Role != apiauth.None {
+		t.Errorf("expected a caller without a mapped group to have no role, got %+v, %v", principal, err)
+	}
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := map[string]string{
+		"expired":     idp.sign(t, idp.key, claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"not yet":     idp.sign(t, idp.key, claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"audience":    idp.sign(t, idp.key, claims(map[string]any{"aud": "other"})),
+		"issuer":      idp.sign(t, idp.key, claims(map[string]any{"iss": "https://evil.example.com"})),
+		"forged":      idp.sign(t, forger, claims(nil)),
+		"not a jwt":   "s.vault-token",
+		"without exp": idp.sign(t, idp.key, claims(map[string]any{"exp": nil})),
+	}
+	for name, token := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := authenticate(token); !errors.Is(err, apiauth.ErrInvalidCredentials) {
+				t.Errorf("expected the token to be rejected, got %v", err)
+			}
+		})
+	}
+
+	if _, ok, err := oidc.Authenticate(request(nil)); ok || err != nil {
+		t.Errorf("expected a request without a token to be skipped, got %v, %v", ok, err)
+	}
+}
+
+func TestOIDC_Unavailable(t *testing.T) {
+	idp := newProvider(t)
+	token := idp.sign(t, idp.key, map[string]any{"iss": idp.URL, "aud": "secretpaths", "exp": time.Now().Add(time.Hour).Unix()})
+	oidc := apiauth.NewOIDC(idp.URL, "secretpaths", "groups", nil, idp.Client())
+	idp.Close()
+
+	_, ok, err := oidc.Authenticate(request(map[string]string{"Authorization": "Bearer " + token}))
+	if !ok || !errors.Is(err, apiauth.ErrUnavailable) || errors.Is(err, apiauth.ErrInvalidCredentials) {
+		t.Errorf("expected the provider to be unavailable, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,31 @@
+package apiauth
+
+import (
+	"context"
+	"net/http"
+)
+
+// VaultTokenHeader carries a Vault token, the same header the Vault API uses.
+const VaultTokenHeader = "X-Vault-Token"
+
+// VaultTokens authenticates requests with a Vault token in the X-Vault-Token header and
+// assigns the highest role of its policies.
+type VaultTokens struct {
+	// Lookup returns the display name and the policies of the token. It returns an error wrapping
+	// ErrInvalidCredentials if Vault rejects the token.
+	Lookup func(ctx context.Context, token string) (string, []string, error)
+	// Roles maps policies to roles, policies without a role are ignored.
+	Roles map[string]Role
+}
+
+func (v VaultTokens) Authenticate(r *http.Request) (Principal, bool, error) {
+	token := r.Header.Get(VaultTokenHeader)
+	if token == "" {
+		return Principal{}, false, nil
+	}
+	name, policies, err := v.Lookup(r.Context(), token)
+	if err != nil {
+		return Principal{}, true, err
+	}
+	return Principal{Name: name, Method: "vault", Role: highest(v.Roles, policies)}, true, nil
+}
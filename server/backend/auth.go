@@ -0,0 +1,288 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuthMethod logs a client in to Vault.
+type AuthMethod interface {
+	// Name identifies the method in logs and errors.
+	Name() string
+	// Login authenticates the client and sets its token.
+	Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error)
+	// Revocable returns true if Login creates a new token, which should be revoked once it is not needed anymore.
+	Revocable() bool
+}
+
+// tokenWatcher is implemented by methods whose token can change outside of secretpaths, e.g. in a Vault Agent sink.
+type tokenWatcher interface {
+	// watch calls changed whenever the token changed, until the context is cancelled.
+	watch(ctx context.Context, changed func())
+}
+
+// tlsConfigurer is implemented by methods which need additional TLS settings, e.g. a client certificate.
+type tlsConfigurer interface {
+	configureTLS(config *TLSConfig)
+}
+
+// TokenAuth uses a static token, e.g. from VAULT_TOKEN. It should not be used in production.
+type TokenAuth struct {
+	Token string
+}
+
+func (a TokenAuth) Name() string    { return "token" }
+func (a TokenAuth) Revocable() bool { return false }
+
+func (a TokenAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Token == "" {
+		return nil, misconfigured(a.Name(), "VAULT_TOKEN is required")
+	}
+	return useToken(ctx, client, a.Name(), a.Token)
+}
+
+// defaultTokenFileInterval is how often a token file is checked for a new token.
+const defaultTokenFileInterval = 10 * time.Second
+
+// TokenFileAuth reads the token from a file, e.g. the sink of a Vault Agent. The file is checked
+// for a new token every Interval, so the agent can rotate the token while the server is running.
+type TokenFileAuth struct {
+	Path     string
+	Interval time.Duration
+}
+
+func (a TokenFileAuth) Name() string    { return "token_file" }
+func (a TokenFileAuth) Revocable() bool { return false }
+
+func (a TokenFileAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Path == "" {
+		return nil, misconfigured(a.Name(), "VAULT_TOKEN_FILE is required")
+	}
+	token, err := readCredential(a.Name(), a.Path)
+	if err != nil {
+		return nil, err
+	}
+	return useToken(ctx, client, a.Name(), token)
+}
+
+// watch polls the file instead of relying on file system events, as agents and Kubernetes
+// replace sink files by renaming or by swapping symlinks, which is easy to miss.
+// The first poll always reports a change, as the file may have changed since the last login.
+func (a TokenFileAuth) watch(ctx context.Context, changed func()) {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = defaultTokenFileInterval
+	}
+	last := ""
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			token, err := readCredential(a.Name(), a.Path)
+			if err != nil || token == last {
+				continue
+			}
+			last = token
+			changed()
+		}
+	}
+}
+
+// useToken sets a static token and looks up how long it is valid.
+func useToken(ctx context.Context, client *vault.Client, method string, token string) (*vault.ResponseAuth, error) {
+	if err := client.SetToken(token); err != nil {
+		return nil, misconfigured(method, "%v", err)
+	}
+	auth, err := lookupSelf(ctx, client)
+	if err != nil {
+		return nil, classify(method, err)
+	}
+	auth.ClientToken = token
+	return auth, nil
+}
+
+type AppRoleAuth struct {
+	RoleId   string
+	SecretId string
+	Mount    string
+}
+
+func (a AppRoleAuth) Name() string    { return "approle" }
+func (a AppRoleAuth) Revocable() bool { return true }
+
+func (a AppRoleAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.RoleId == "" || a.SecretId == "" {
+		return nil, misconfigured(a.Name(), "both APPROLE_ROLE_ID and APPROLE_SECRET_ID are required")
+	}
+	resp, err := client.Auth.AppRoleLogin(
+		ctx,
+		schema.AppRoleLoginRequest{
+			RoleId:   a.RoleId,
+			SecretId: a.SecretId,
+		},
+		vault.WithMountPath(a.Mount),
+	)
+	return setLoginToken(client, a.Name(), resp, err)
+}
+
+const (
+	// defaultKubernetesTokenPath is where Kubernetes mounts the token of the service account of a pod.
+	defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubernetesMount     = "kubernetes"
+)
+
+// KubernetesAuth logs in with the token of the service account of the pod. TokenPath can point
+// to a projected token, it is read on every login, so tokens rotated by the kubelet are picked up.
+type KubernetesAuth struct {
+	Role      string
+	TokenPath string
+	Mount     string
+}
+
+func (a KubernetesAuth) Name() string    { return "kubernetes" }
+func (a KubernetesAuth) Revocable() bool { return true }
+
+func (a KubernetesAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Role == "" {
+		return nil, misconfigured(a.Name(), "KUBERNETES_ROLE is required")
+	}
+	path := a.TokenPath
+	if path == "" {
+		path = defaultKubernetesTokenPath
+	}
+	jwt, err := readCredential(a.Name(), path)
+	if err != nil {
+		return nil, err
+	}
+	mount := a.Mount
+	if mount == "" {
+		mount = defaultKubernetesMount
+	}
+
+	resp, err := client.Auth.KubernetesLogin(
+		ctx,
+		schema.KubernetesLoginRequest{
+			Role: a.Role,
+			Jwt:  jwt,
+		},
+		vault.WithMountPath(mount),
+	)
+	return setLoginToken(client, a.Name(), resp, err)
+}
+
+// JWTAuth logs in with a JWT read from a file, e.g. a projected service account token or a CI job token.
+// The file is read on every login, so rotated tokens are picked up.
+type JWTAuth struct {
+	Role  string
+	Path  string
+	Mount string
+}
+
+func (a JWTAuth) Name() string    { return "jwt" }
+func (a JWTAuth) Revocable() bool { return true }
+
+func (a JWTAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Path == "" {
+		return nil, misconfigured(a.Name(), "JWT_TOKEN_FILE is required")
+	}
+	jwt, err := readCredential(a.Name(), a.Path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Auth.JwtLogin(ctx, schema.JwtLoginRequest{Jwt: jwt, Role: a.Role}, vault.WithMountPath(a.Mount))
+	return setLoginToken(client, a.Name(), resp, err)
+}
+
+type UserpassAuth struct {
+	Username string
+	Password string
+	Mount    string
+}
+
+func (a UserpassAuth) Name() string    { return "userpass" }
+func (a UserpassAuth) Revocable() bool { return true }
+
+func (a UserpassAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Username == "" || a.Password == "" {
+		return nil, misconfigured(a.Name(), "both USERPASS_USERNAME and USERPASS_PASSWORD are required")
+	}
+	resp, err := client.Auth.UserpassLogin(ctx, a.Username, schema.UserpassLoginRequest{Password: a.Password}, vault.WithMountPath(a.Mount))
+	return setLoginToken(client, a.Name(), resp, err)
+}
+
+type LDAPAuth struct {
+	Username string
+	Password string
+	Mount    string
+}
+
+func (a LDAPAuth) Name() string    { return "ldap" }
+func (a LDAPAuth) Revocable() bool { return true }
+
+func (a LDAPAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Username == "" || a.Password == "" {
+		return nil, misconfigured(a.Name(), "both LDAP_USERNAME and LDAP_PASSWORD are required")
+	}
+	resp, err := client.Auth.LdapLogin(ctx, a.Username, schema.LdapLoginRequest{Password: a.Password}, vault.WithMountPath(a.Mount))
+	return setLoginToken(client, a.Name(), resp, err)
+}
+
+// CertAuth logs in with a TLS client certificate. Role is optional, without it Vault
+// tries all roles of the mount.
+type CertAuth struct {
+	Role        string
+	Certificate string
+	Key         string
+	Mount       string
+}
+
+func (a CertAuth) Name() string    { return "cert" }
+func (a CertAuth) Revocable() bool { return true }
+
+func (a CertAuth) configureTLS(config *TLSConfig) {
+	config.ClientCert = a.Certificate
+	config.ClientKey = a.Key
+}
+
+func (a CertAuth) Login(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	if a.Certificate == "" || a.Key == "" {
+		return nil, misconfigured(a.Name(), "both VAULT_CLIENT_CERT and VAULT_CLIENT_KEY are required")
+	}
+	resp, err := client.Auth.CertLogin(ctx, schema.CertLoginRequest{Name: a.Role}, vault.WithMountPath(a.Mount))
+	return setLoginToken(client, a.Name(), resp, err)
+}
+
+// setLoginToken sets the token of a login response on the client.
+func setLoginToken(client *vault.Client, method string, resp *vault.Response[map[string]interface{}], err error) (*vault.ResponseAuth, error) {
+	if err != nil {
+		return nil, classify(method, err)
+	}
+	if resp.Auth == nil {
+		return nil, &AuthError{Method: method, Kind: ErrPermissionDenied, Err: fmt.Errorf("login returned no token")}
+	}
+	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
+		return nil, classify(method, err)
+	}
+	return resp.Auth, nil
+}
+
+// readCredential reads a token or JWT from a file, surrounding whitespace is removed.
+func readCredential(method, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", misconfigured(method, "could not read %s: %v", path, err)
+	}
+	credential := strings.TrimSpace(string(content))
+	if credential == "" {
+		return "", misconfigured(method, "%s is empty", path)
+	}
+	return credential, nil
+}
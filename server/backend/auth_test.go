@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCredential(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "credential")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthMethods_Login(t *testing.T) {
+	methods := []AuthMethod{
+		JWTAuth{Role: "secretpaths", Path: writeCredential(t, "eyJhbGciOiJSUzI1NiJ9.e30.c2lnbmF0dXJl\n")},
+		UserpassAuth{Username: "alice", Password: "password"},
+		LDAPAuth{Username: "alice", Password: "password"},
+		TokenFileAuth{Path: writeCredential(t, "s.agent\n")},
+		KubernetesAuth{Role: "secretpaths", TokenPath: writeCredential(t, "eyJhbGciOiJSUzI1NiJ9.e30.c2lnbmF0dXJl\n")},
+	}
+	for _, method := range methods {
+		t.Run(method.Name(), func(t *testing.T) {
+			manager := newFakeVault(t).manager(method)
+			if _, err := manager.Client(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAuthMethods_Misconfigured(t *testing.T) {
+	methods := []AuthMethod{
+		JWTAuth{Path: filepath.Join(t.TempDir(), "missing")},
+		TokenFileAuth{Path: writeCredential(t, "  \n")},
+		UserpassAuth{Username: "alice"},
+		CertAuth{},
+		KubernetesAuth{TokenPath: writeCredential(t, "eyJhbGciOiJSUzI1NiJ9.e30.c2lnbmF0dXJl")},
+		KubernetesAuth{Role: "secretpaths", TokenPath: filepath.Join(t.TempDir(), "missing")},
+	}
+	for _, method := range methods {
+		t.Run(method.Name(), func(t *testing.T) {
+			if _, err := newFakeVault(t).manager(method).Client(context.Background()); !errors.Is(err, ErrMisconfigured) {
+				t.Errorf("expected a misconfiguration, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenFileAuth_Reload(t *testing.T) {
+	vault := newFakeVault(t)
+	path := writeCredential(t, "s.first")
+	manager := vault.manager(TokenFileAuth{Path: path, Interval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, err := manager.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start(ctx)
+
+	if err := os.WriteFile(path, []byte("s.second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for vault.token() != "s.second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the new token to be used, got %s", vault.token())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	current, _ := manager.Client(ctx)
+	if current != client {
+		t.Error("expected the token to be swapped on the existing client")
+	}
+}
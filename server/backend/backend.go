@@ -2,98 +2,85 @@ package backend
 
 import (
 	"context"
-	"fmt"
 	"github.com/hashicorp/vault-client-go"
-	"github.com/hashicorp/vault-client-go/schema"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"secretpaths/logging"
 	"time"
 )
 
+// Connection describes how to reach Vault.
+type Connection struct {
+	Address string
+	TLS     TLSConfig
+	// Instrument wraps the transport of the client if it is set, e.g. to count the requests to Vault.
+	Instrument func(http.RoundTripper) http.RoundTripper
+}
+
+// SetupConnection returns a client for the Vault at VAULT_ADDR, it is used by the demo to seed a Vault.
 func SetupConnection() *vault.Client {
-	serverAddress := "http://127.0.0.1:8200"
+	connection := Connection{Address: "http://127.0.0.1:8200"}
 
 	val, ok := os.LookupEnv("VAULT_ADDR")
 
 	if ok {
-		serverAddress = val
+		connection.Address = val
 	}
 
-	client, err := vault.New(
-		vault.WithAddress(serverAddress),
-		vault.WithRequestTimeout(30*time.Second),
-	)
+	client, err := setupConnection(connection, nil)
 	if err != nil {
-		log.Printf("could not login: error: %v", err)
-		log.Println(err)
+		slog.Error("could not login", logging.Error(err))
 	}
 	return client
 }
 
-func AutoAuth(ctx context.Context) (*vault.Client, error) {
-	if os.Getenv("KUBERNETES_ROLE") != "" {
-		log.Println("using kubernetes authentication")
-		return useKubernetes(ctx), nil
+// setupConnection returns a client for the connection, whose TLS settings can be adjusted by the configurer
+// of an authentication method.
+func setupConnection(connection Connection, configurer tlsConfigurer, options ...vault.ClientOption) (*vault.Client, error) {
+	tlsConfig := connection.TLS
+	if configurer != nil {
+		configurer.configureTLS(&tlsConfig)
 	}
-	if os.Getenv("APPROLE_ROLE_ID") != "" && os.Getenv("APPROLE_SECRET_ID") != "" {
-		log.Println("using approle authentication")
-		return useAppRole(ctx), nil
+	httpClient, err := tlsConfig.httpClient(connection.Address)
+	if err != nil {
+		return nil, err
 	}
-	if os.Getenv("VAULT_TOKEN") != "" {
-		log.Println("using token authentication")
-		return UseToken(os.Getenv("VAULT_TOKEN")), nil
+	if connection.Instrument != nil {
+		httpClient.Transport = connection.Instrument(httpClient.Transport)
 	}
-	return nil, fmt.Errorf("no authentication method found")
-}
 
-func UseToken(token string) *vault.Client {
-	client := SetupConnection()
-
-	if err := client.SetToken(token); err != nil {
-		log.Println(err)
+	client, err := vault.New(append([]vault.ClientOption{
+		vault.WithAddress(connection.Address),
+		vault.WithHTTPClient(httpClient),
+		vault.WithRequestTimeout(30 * time.Second),
+	}, options...)...)
+	if err != nil {
+		return nil, misconfigured("vault", "could not set up a client for %s: %v", connection.Address, err)
 	}
-	return client
+	return client, nil
 }
 
-func useAppRole(ctx context.Context) *vault.Client {
-	client := SetupConnection()
-
-	resp, err := client.Auth.AppRoleLogin(
-		ctx,
-		schema.AppRoleLoginRequest{
-			RoleId:   os.Getenv("APPROLE_ROLE_ID"),
-			SecretId: os.Getenv("APPROLE_SECRET_ID"),
-		},
-	)
+// connect sets up a new client and logs it in with the method. Errors are of type *AuthError.
+func connect(ctx context.Context, connection Connection, method AuthMethod, options ...vault.ClientOption) (*vault.Client, *vault.ResponseAuth, error) {
+	configurer, _ := method.(tlsConfigurer)
+	client, err := setupConnection(connection, configurer, options...)
 	if err != nil {
-		log.Println(err)
+		return nil, nil, err
 	}
-
-	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		log.Println(err)
+	logging.FromContext(ctx).Info("logging in", "method", method.Name())
+	auth, err := method.Login(ctx, client)
+	if err != nil {
+		return nil, nil, err
 	}
-	return client
+	return client, auth, nil
 }
 
-func useKubernetes(ctx context.Context) *vault.Client {
+func UseToken(token string) *vault.Client {
 	client := SetupConnection()
 
-	file, _ := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-
-	resp, err := client.Auth.KubernetesLogin(
-		ctx,
-		schema.KubernetesLoginRequest{
-			Role: os.Getenv("KUBERNETES_ROLE"),
-			Jwt:  string(file),
-		},
-		vault.WithMountPath(os.Getenv("KUBERNETES_PATH")),
-	)
-	if err != nil {
-		log.Println(err)
-	}
-
-	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		log.Println(err)
+	if err := client.SetToken(token); err != nil {
+		slog.Error("could not use the token", logging.Error(err))
 	}
 	return client
 }
@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"net/http"
+)
+
+var (
+	// ErrMisconfigured means the authentication method is missing settings or cannot read its credentials.
+	ErrMisconfigured = errors.New("misconfigured")
+	// ErrUnreachable means Vault could not be reached or is not able to answer, e.g. because it is sealed.
+	ErrUnreachable = errors.New("vault unreachable")
+	// ErrPermissionDenied means Vault rejected the credentials.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// AuthError is returned by the authentication methods, Kind is one of ErrMisconfigured,
+// ErrUnreachable or ErrPermissionDenied and can be checked with errors.Is.
+type AuthError struct {
+	Method string
+	Kind   error
+	Err    error
+}
+
+func (e *AuthError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s authentication: %v", e.Method, e.Kind)
+	}
+	return fmt.Sprintf("%s authentication: %v: %v", e.Method, e.Kind, e.Err)
+}
+
+func (e *AuthError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func misconfigured(method string, format string, args ...any) error {
+	return &AuthError{Method: method, Kind: ErrMisconfigured, Err: fmt.Errorf(format, args...)}
+}
+
+// classify wraps an error returned by a request to Vault into an AuthError.
+func classify(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var authError *AuthError
+	if errors.As(err, &authError) {
+		return err
+	}
+	kind := ErrUnreachable
+	var responseError *vault.ResponseError
+	if errors.As(err, &responseError) {
+		switch responseError.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			kind = ErrPermissionDenied
+		case http.StatusNotFound:
+			// the auth method is not enabled at the mount
+			kind = ErrMisconfigured
+		}
+	}
+	return &AuthError{Method: method, Kind: kind, Err: err}
+}
@@ -0,0 +1,299 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"net/http"
+	"net/url"
+	"secretpaths/logging"
+	"sync"
+	"time"
+)
+
+const (
+	// minRenewInterval keeps tokens with a very short TTL from being renewed in a tight loop.
+	minRenewInterval = 5 * time.Second
+	// retryInterval is how long to wait before logging in again after a failed login.
+	retryInterval = 10 * time.Second
+	// checkInterval is how often tokens that never expire are checked for validity.
+	checkInterval = time.Hour
+)
+
+// Manager owns the authenticated client of the server. It logs in once, renews the token in the
+// background via auth/token/renew-self and logs in again once the token expired, could not be renewed
+// or Vault rejected it. Tokens created by the manager are revoked by Close.
+type Manager struct {
+	connection Connection
+	method     AuthMethod
+
+	mu        sync.RWMutex
+	client    *vault.Client
+	token     string
+	expiresAt time.Time
+	renewable bool
+	owned     bool
+	lastError error
+
+	forbidden chan struct{}
+}
+
+// NewManagerWith returns a manager logging in with the given method to the Vault of the connection.
+func NewManagerWith(connection Connection, method AuthMethod) *Manager {
+	return &Manager{connection: connection, method: method, forbidden: make(chan struct{}, 1)}
+}
+
+// Client returns the authenticated client, logging in first if there is none yet.
+func (m *Manager) Client(ctx context.Context) (*vault.Client, error) {
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+	if client != nil {
+		return client, nil
+	}
+	return m.login(ctx, false)
+}
+
+// Start renews the token in the background until the context is cancelled.
+// If the token is read from a file, the file is watched and a new token is used as soon as it is written.
+func (m *Manager) Start(ctx context.Context) {
+	if watcher, ok := m.method.(tokenWatcher); ok {
+		go watcher.watch(ctx, func() {
+			m.reload(ctx)
+		})
+	}
+	go func() {
+		for {
+			timer := time.NewTimer(m.nextRenewal())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-m.forbidden:
+				timer.Stop()
+				m.verify(ctx)
+			case <-timer.C:
+				m.renew(ctx)
+			}
+		}
+	}()
+}
+
+// Err returns the error of the last login, it is nil once the manager is authenticated.
+func (m *Manager) Err() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastError
+}
+
+// Check reports whether Vault is initialized, unsealed and still accepts the token, logging in first if
+// there is no client yet. Standbys count as healthy, they forward requests to the active node.
+// The errors can be checked with errors.Is against ErrUnreachable and ErrPermissionDenied.
+func (m *Manager) Check(ctx context.Context) error {
+	client, err := m.Client(ctx)
+	if err != nil {
+		return err
+	}
+	health, err := client.System.ReadHealthStatus(ctx, vault.WithQueryParameters(url.Values{
+		"standbyok":     {"true"},
+		"perfstandbyok": {"true"},
+	}))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	// the client does not treat the status codes of an unhealthy Vault as errors, only the body tells
+	if initialized, _ := health.Data["initialized"].(bool); !initialized {
+		return fmt.Errorf("%w: vault is not initialized", ErrUnreachable)
+	}
+	if sealed, _ := health.Data["sealed"].(bool); sealed {
+		return fmt.Errorf("%w: vault is sealed", ErrUnreachable)
+	}
+	if _, err := lookupSelf(ctx, client); err != nil {
+		if isForbidden(err) {
+			select {
+			case m.forbidden <- struct{}{}:
+			default:
+			}
+			return fmt.Errorf("%w: %w", ErrPermissionDenied, err)
+		}
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	return nil
+}
+
+// Token returns the token of the client, logging in first if there is none yet. It authenticates the requests
+// the client cannot make itself, like subscribing to events over a websocket.
+func (m *Manager) Token(ctx context.Context) (string, error) {
+	if _, err := m.Client(ctx); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.token, nil
+}
+
+// Close revokes the token if the manager created it.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client := m.client
+	m.client = nil
+	if client == nil || !m.owned {
+		return nil
+	}
+	if _, err := client.Auth.TokenRevokeSelf(ctx); err != nil {
+		return fmt.Errorf("could not revoke token: %w", err)
+	}
+	logging.FromContext(ctx).Info("revoked the vault token")
+	return nil
+}
+
+// login replaces the client with a newly authenticated one. Unless forced, an existing client is kept,
+// so concurrent callers of Client only log in once.
+func (m *Manager) login(ctx context.Context, force bool) (*vault.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil && !force {
+		return m.client, nil
+	}
+	client, auth, err := connect(ctx, m.connection, m.method, vault.WithRetryConfiguration(m.retryConfiguration()))
+	m.lastError = err
+	if err != nil {
+		return nil, err
+	}
+	if m.client != nil && m.owned {
+		// the old token is replaced, there is no need to keep it alive until it expires
+		go func(old *vault.Client) {
+			_, _ = old.Auth.TokenRevokeSelf(context.Background())
+		}(m.client)
+	}
+	m.client = client
+	m.owned = m.method.Revocable()
+	m.setLease(auth)
+	return client, nil
+}
+
+func (m *Manager) setLease(auth *vault.ResponseAuth) {
+	m.expiresAt = time.Time{}
+	m.renewable = false
+	if auth == nil {
+		return
+	}
+	if auth.ClientToken != "" {
+		m.token = auth.ClientToken
+	}
+	if auth.LeaseDuration > 0 {
+		m.expiresAt = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second)
+	}
+	m.renewable = auth.Renewable
+}
+
+// nextRenewal renews tokens after two thirds of their remaining TTL.
+func (m *Manager) nextRenewal() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.client == nil {
+		return retryInterval
+	}
+	if m.expiresAt.IsZero() {
+		return checkInterval
+	}
+	return max(time.Until(m.expiresAt)*2/3, minRenewInterval)
+}
+
+func (m *Manager) renew(ctx context.Context) {
+	m.mu.RLock()
+	client, renewable, expiresAt := m.client, m.renewable, m.expiresAt
+	m.mu.RUnlock()
+
+	if client != nil && expiresAt.IsZero() {
+		m.verify(ctx)
+		return
+	}
+	if client != nil && renewable {
+		resp, err := client.Auth.TokenRenewSelf(ctx, schema.TokenRenewSelfRequest{})
+		if err == nil && resp.Auth != nil {
+			m.mu.Lock()
+			m.setLease(resp.Auth)
+			m.mu.Unlock()
+			return
+		}
+		logging.FromContext(ctx).Warn("could not renew the token, logging in again", logging.Error(err))
+	}
+	if _, err := m.login(ctx, true); err != nil {
+		logging.FromContext(ctx).Error("could not login", logging.Error(err))
+	}
+}
+
+// reload logs the current client in again, so a new token is used without setting up a new client.
+func (m *Manager) reload(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client == nil {
+		return
+	}
+	auth, err := m.method.Login(ctx, m.client)
+	m.lastError = err
+	if err != nil {
+		logging.FromContext(ctx).Error("could not use the new token", logging.Error(err))
+		return
+	}
+	m.setLease(auth)
+	logging.FromContext(ctx).Info("using the new token", "method", m.method.Name())
+}
+
+// verify logs in again if Vault no longer accepts the token.
+func (m *Manager) verify(ctx context.Context) {
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+	if client == nil {
+		return
+	}
+	if _, err := lookupSelf(ctx, client); err != nil && isForbidden(err) {
+		logging.FromContext(ctx).Warn("vault rejected the token, logging in again")
+		if _, err := m.login(ctx, true); err != nil {
+			logging.FromContext(ctx).Error("could not login", logging.Error(err))
+		}
+	}
+}
+
+// retryConfiguration reports every 403 response to the manager, so it can check whether the token is still valid.
+func (m *Manager) retryConfiguration() vault.RetryConfiguration {
+	configuration := vault.DefaultConfiguration().RetryConfiguration
+	configuration.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if resp != nil && resp.StatusCode == http.StatusForbidden {
+			select {
+			case m.forbidden <- struct{}{}:
+			default:
+			}
+		}
+		return vault.DefaultRetryPolicy(ctx, resp, err)
+	}
+	return configuration
+}
+
+func isForbidden(err error) bool {
+	var responseError *vault.ResponseError
+	return errors.As(err, &responseError) && responseError.StatusCode == http.StatusForbidden
+}
+
+// lookupSelf returns the lease of the token the client uses.
+func lookupSelf(ctx context.Context, client *vault.Client) (*vault.ResponseAuth, error) {
+	resp, err := client.Auth.TokenLookUpSelf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auth := &vault.ResponseAuth{}
+	if ttl, ok := resp.Data["ttl"].(json.Number); ok {
+		seconds, _ := ttl.Int64()
+		auth.LeaseDuration = int(seconds)
+	}
+	if renewable, ok := resp.Data["renewable"].(bool); ok {
+		auth.Renewable = renewable
+	}
+	return auth, nil
+}
@@ -0,0 +1,256 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeVault answers the token and approle endpoints used by the manager and counts the calls.
+type fakeVault struct {
+	address   string
+	mu        sync.Mutex
+	calls     map[string]int
+	lastToken string
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	vault := &fakeVault{calls: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vault.mu.Lock()
+		vault.calls[r.URL.Path]++
+		vault.lastToken = r.Header.Get("X-Vault-Token")
+		vault.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/auth/approle/login", "/v1/auth/kubernetes/login", "/v1/auth/jwt/login", "/v1/auth/userpass/login/alice", "/v1/auth/ldap/login/alice":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{},
+				"auth": map[string]any{"client_token": "s.approle", "lease_duration": 3600, "renewable": true},
+			})
+		case "/v1/auth/token/renew-self":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{},
+				"auth": map[string]any{"client_token": "s.approle", "lease_duration": 7200, "renewable": true},
+			})
+		case "/v1/auth/token/lookup-self":
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"ttl": 0, "renewable": false}})
+		case "/v1/auth/approle-denied/login":
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{"errors": []string{"invalid role or secret ID"}})
+		case "/v1/sys/health":
+			if r.URL.Query().Get("standbyok") != "true" {
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"initialized": true, "sealed": false, "standby": true})
+		case "/v1/auth/token/revoke-self":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	vault.address = server.URL
+	return vault
+}
+
+// manager returns a manager logging in to the fake with the method.
+func (v *fakeVault) manager(method AuthMethod) *Manager {
+	return NewManagerWith(Connection{Address: v.address}, method)
+}
+
+func (v *fakeVault) token() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.lastToken
+}
+
+func (v *fakeVault) count(path string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.calls[path]
+}
+
+func TestManager_LogsInOnce(t *testing.T) {
+	vault := newFakeVault(t)
+
+	manager := vault.manager(AppRoleAuth{RoleId: "role", SecretId: "secret"})
+	for i := 0; i < 3; i++ {
+		if _, err := manager.Client(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if logins := vault.count("/v1/auth/approle/login"); logins != 1 {
+		t.Errorf("expected: %d, got: %d logins", 1, logins)
+	}
+}
+
+func TestManager_RenewsToken(t *testing.T) {
+	vault := newFakeVault(t)
+
+	manager := vault.manager(AppRoleAuth{RoleId: "role", SecretId: "secret"})
+	if _, err := manager.Client(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	manager.renew(context.Background())
+	if renewals := vault.count("/v1/auth/token/renew-self"); renewals != 1 {
+		t.Errorf("expected: %d, got: %d renewals", 1, renewals)
+	}
+	if logins := vault.count("/v1/auth/approle/login"); logins != 1 {
+		t.Errorf("expected a renewed token not to log in again, got %d logins", logins)
+	}
+	if next := manager.nextRenewal(); next.Hours() < 1 {
+		t.Errorf("expected the next renewal to be based on the renewed TTL, got %v", next)
+	}
+}
+
+func TestManager_Close(t *testing.T) {
+	t.Run("revokes tokens created by a login", func(t *testing.T) {
+		vault := newFakeVault(t)
+
+		manager := vault.manager(AppRoleAuth{RoleId: "role", SecretId: "secret"})
+		if _, err := manager.Client(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := manager.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if revocations := vault.count("/v1/auth/token/revoke-self"); revocations != 1 {
+			t.Errorf("expected: %d, got: %d revocations", 1, revocations)
+		}
+	})
+	t.Run("keeps static tokens", func(t *testing.T) {
+		vault := newFakeVault(t)
+
+		manager := vault.manager(TokenAuth{Token: "s.static"})
+		if _, err := manager.Client(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := manager.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if revocations := vault.count("/v1/auth/token/revoke-self"); revocations != 0 {
+			t.Errorf("expected: %d, got: %d revocations", 0, revocations)
+		}
+	})
+}
+
+func TestManager_Token(t *testing.T) {
+	t.Run("login", func(t *testing.T) {
+		vault := newFakeVault(t)
+		token, err := vault.manager(AppRoleAuth{RoleId: "role", SecretId: "secret"}).Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "s.approle" {
+			t.Errorf("expected: %s, got: %s", "s.approle", token)
+		}
+	})
+	t.Run("static token", func(t *testing.T) {
+		vault := newFakeVault(t)
+		token, err := vault.manager(TokenAuth{Token: "s.static"}).Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "s.static" {
+			t.Errorf("expected: %s, got: %s", "s.static", token)
+		}
+	})
+}
+
+func TestManager_Errors(t *testing.T) {
+	t.Run("missing token", func(t *testing.T) {
+		vault := newFakeVault(t)
+		manager := vault.manager(TokenAuth{})
+		_, err := manager.Client(context.Background())
+		if !errors.Is(err, ErrMisconfigured) {
+			t.Errorf("expected a misconfiguration, got %v", err)
+		}
+		if manager.Err() == nil {
+			t.Error("expected the manager to report the failed login")
+		}
+	})
+	t.Run("incomplete approle", func(t *testing.T) {
+		vault := newFakeVault(t)
+		_, err := vault.manager(AppRoleAuth{RoleId: "role"}).Client(context.Background())
+		if !errors.Is(err, ErrMisconfigured) {
+			t.Errorf("expected a misconfiguration, got %v", err)
+		}
+	})
+	t.Run("rejected credentials", func(t *testing.T) {
+		fake := newFakeVault(t)
+		client, err := setupConnection(Connection{Address: fake.address}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Auth.AppRoleLogin(context.Background(), schema.AppRoleLoginRequest{}, vault.WithMountPath("approle-denied"))
+		if err = classify("approle", err); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("expected permission denied, got %v", err)
+		}
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		_, err := NewManagerWith(Connection{Address: "http://127.0.0.1:1"}, TokenAuth{Token: "s.static"}).Client(context.Background())
+		if !errors.Is(err, ErrUnreachable) {
+			t.Errorf("expected vault to be unreachable, got %v", err)
+		}
+	})
+}
+
+func TestManager_Check(t *testing.T) {
+	t.Run("healthy", func(t *testing.T) {
+		vault := newFakeVault(t)
+		if err := vault.manager(TokenAuth{Token: "s.static"}).Check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if checks := vault.count("/v1/sys/health"); checks != 1 {
+			t.Errorf("expected: %d, got: %d health checks", 1, checks)
+		}
+		if lookups := vault.count("/v1/auth/token/lookup-self"); lookups != 2 {
+			t.Errorf("expected the token to be looked up by the login and the check, got %d lookups", lookups)
+		}
+	})
+	t.Run("sealed", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/sys/health" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(`{"initialized": true, "sealed": true}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": {"ttl": 0}}`))
+		}))
+		defer server.Close()
+		manager := NewManagerWith(Connection{Address: server.URL}, TokenAuth{Token: "s.static"})
+		if err := manager.Check(context.Background()); !errors.Is(err, ErrUnreachable) {
+			t.Errorf("expected vault to be unreachable, got %v", err)
+		}
+	})
+	t.Run("rejected token", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/sys/health" {
+				_, _ = w.Write([]byte(`{"initialized": true, "sealed": false}`))
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer server.Close()
+		manager := NewManagerWith(Connection{Address: server.URL}, TokenAuth{Token: "s.revoked"})
+		if err := manager.Check(context.Background()); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("expected the token to be rejected, got %v", err)
+		}
+		if len(manager.forbidden) != 1 {
+			t.Error("expected the manager to verify the token")
+		}
+	})
+	t.Run("misconfigured", func(t *testing.T) {
+		vault := newFakeVault(t)
+		if err := vault.manager(TokenAuth{}).Check(context.Background()); !errors.Is(err, ErrMisconfigured) {
+			t.Errorf("expected a misconfiguration, got %v", err)
+		}
+	})
+}
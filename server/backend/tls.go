@@ -0,0 +1,174 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault-client-go"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TLSConfig holds the settings for the connection to Vault, they use the same environment variables as the Vault CLI.
+type TLSConfig struct {
+	// CACert is a PEM file with the certificates of the CAs which are trusted, VAULT_CACERT.
+	CACert string
+	// CAPath is a directory of PEM files with the certificates of the CAs which are trusted, VAULT_CAPATH.
+	CAPath string
+	// ClientCert and ClientKey are a certificate and its key presented to Vault, VAULT_CLIENT_CERT and VAULT_CLIENT_KEY.
+	ClientCert string
+	ClientKey  string
+	// ServerName is expected in the certificate of Vault instead of the host of VAULT_ADDR, VAULT_TLS_SERVER_NAME.
+	ServerName string
+	// SkipVerify disables the verification of the certificate of Vault, VAULT_SKIP_VERIFY. Only use it for development.
+	SkipVerify bool
+}
+
+// httpClient returns a client for the Vault API at address using the settings. The CA certificates are read again
+// whenever a new connection is made, so a rotated CA bundle is used without restarting the server.
+func (c TLSConfig) httpClient(address string) (*http.Client, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return nil, misconfigured("tls", "both VAULT_CLIENT_CERT and VAULT_CLIENT_KEY are required")
+	}
+	if c.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, misconfigured("tls", "could not load the client certificate: %v", err)
+		}
+		// present the certificate even if Vault does not list its CA as acceptable,
+		// the CAs of the cert auth method are not part of the server's list
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &certificate, nil
+		}
+	}
+	switch {
+	case c.SkipVerify:
+		config.InsecureSkipVerify = true
+	case c.CACert != "" || c.CAPath != "":
+		// crypto/tls leaves the server name of the connection empty for IP addresses,
+		// so the host is taken from the address to always be verified
+		host := c.ServerName
+		if host == "" {
+			parsed, err := url.Parse(address)
+			if err != nil || parsed.Hostname() == "" {
+				return nil, misconfigured("tls", "could not read the host of %q to verify the certificate of Vault", address)
+			}
+			host = parsed.Hostname()
+		}
+		authorities := &certificateAuthorities{file: c.CACert, directory: c.CAPath}
+		if _, err := authorities.load(); err != nil {
+			return nil, misconfigured("tls", "%v", err)
+		}
+		// the default verification only supports a fixed pool, so it is replaced by one that reloads the CAs
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = authorities.verify(host)
+	}
+
+	client := vault.DefaultConfiguration().HTTPClient
+	client.Transport.(*http.Transport).TLSClientConfig = config
+	return client, nil
+}
+
+// certificateAuthorities is a pool of CA certificates read from a file and a directory.
+// The pool is only parsed again when the content of the files changed.
+type certificateAuthorities struct {
+	file      string
+	directory string
+
+	mu      sync.Mutex
+	content []byte
+	pool    *x509.CertPool
+}
+
+func (a *certificateAuthorities) load() (*x509.CertPool, error) {
+	content, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pool != nil && bytes.Equal(content, a.content) {
+		return a.pool, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no CA certificates found in %s", a.source())
+	}
+	a.content, a.pool = content, pool
+	return pool, nil
+}
+
+func (a *certificateAuthorities) read() ([]byte, error) {
+	var content []byte
+	if a.file != "" {
+		data, err := os.ReadFile(a.file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA certificates: %w", err)
+		}
+		content = append(content, data...)
+	}
+	if a.directory != "" {
+		entries, err := os.ReadDir(a.directory)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA certificates: %w", err)
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			// directories mounted from Kubernetes secrets contain hidden directories with older versions
+			if !entry.IsDir() && entry.Name()[0] != '.' {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			data, err := os.ReadFile(filepath.Join(a.directory, name))
+			if err != nil {
+				return nil, fmt.Errorf("could not read the CA certificates: %w", err)
+			}
+			content = append(append(content, data...), '\n')
+		}
+	}
+	return content, nil
+}
+
+func (a *certificateAuthorities) source() string {
+	return strings.Trim(a.file+" "+a.directory, " ")
+}
+
+// verify checks the certificate of the server like the default verification, but against the current CAs.
+// The certificate must be issued for host, a name or an IP address.
+func (a *certificateAuthorities) verify(host string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if host == "" {
+			return errors.New("there is no host to verify the certificate of vault against")
+		}
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("vault did not present a certificate")
+		}
+		pool, err := a.load()
+		if err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, certificate := range state.PeerCertificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+		_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         pool,
+			Intermediates: intermediates,
+			DNSName:       host,
+		})
+		return err
+	}
+}
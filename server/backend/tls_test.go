@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTLSVault starts a server answering lookup-self over TLS.
+func newTLSVault(t *testing.T) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {"ttl": 0}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeCertificate(t *testing.T, path string, certificate []byte) {
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: certificate}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// otherCA returns a self-signed certificate, which did not sign the certificate of the test server.
+func otherCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func TestTLSConfig_CACert(t *testing.T) {
+	server := newTLSVault(t)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writeCertificate(t, path, otherCA(t))
+
+	client, err := setupConnection(Connection{Address: server.URL, TLS: TLSConfig{CACert: path}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetToken("s.static"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Auth.TokenLookUpSelf(context.Background()); err == nil {
+		t.Fatal("expected a certificate of an unknown CA to be rejected")
+	}
+
+	writeCertificate(t, path, server.Certificate().Raw)
+	if _, err := client.Auth.TokenLookUpSelf(context.Background()); err != nil {
+		t.Errorf("expected the reloaded CA to be used, got %v", err)
+	}
+}
+
+func TestTLSConfig_CAPath(t *testing.T) {
+	server := newTLSVault(t)
+	directory := t.TempDir()
+	writeCertificate(t, filepath.Join(directory, "vault.pem"), server.Certificate().Raw)
+	connection := Connection{Address: server.URL, TLS: TLSConfig{CAPath: directory, ServerName: "example.com"}}
+
+	if _, err := NewManagerWith(connection, TokenAuth{Token: "s.static"}).Client(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// issuedFor returns a CA and a certificate it issued for the IP address.
+func issuedFor(t *testing.T, ip net.IP) ([]byte, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	ca, err := x509.CreateCertificate(rand.Reader, authority, authority, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: ip.String()},
+		IPAddresses:  []net.IP{ip},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, leaf, authority, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: key}
+}
+
+func TestTLSConfig_IPAddress(t *testing.T) {
+	tests := map[string]struct {
+		ip    net.IP
+		valid bool
+	}{
+		"matching address": {net.ParseIP("127.0.0.1"), true},
+		"other address":    {net.ParseIP("10.9.9.9"), false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ca, certificate := issuedFor(t, test.ip)
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"data": {"ttl": 0}}`))
+			}))
+			server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+			server.StartTLS()
+			defer server.Close()
+			path := filepath.Join(t.TempDir(), "ca.pem")
+			writeCertificate(t, path, ca)
+			connection := Connection{Address: server.URL, TLS: TLSConfig{CACert: path}}
+
+			_, err := NewManagerWith(connection, TokenAuth{Token: "s.static"}).Client(context.Background())
+			if test.valid && err != nil {
+				t.Errorf("expected the certificate to be accepted, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected a certificate for %s to be rejected at %s", test.ip, server.URL)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_SkipVerify(t *testing.T) {
+	server := newTLSVault(t)
+	connection := Connection{Address: server.URL, TLS: TLSConfig{SkipVerify: true}}
+
+	if _, err := NewManagerWith(connection, TokenAuth{Token: "s.static"}).Client(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTLSConfig_Misconfigured(t *testing.T) {
+	tests := map[string]TLSConfig{
+		"missing CA file":  {CACert: filepath.Join(t.TempDir(), "missing.pem")},
+		"no certificates":  {CACert: writeCredential(t, "not a certificate")},
+		"certificate only": {ClientCert: writeCredential(t, "not a certificate")},
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := newTLSVault(t)
+			manager := NewManagerWith(Connection{Address: server.URL, TLS: config}, TokenAuth{Token: "s.static"})
+			if _, err := manager.Client(context.Background()); !errors.Is(err, ErrMisconfigured) {
+				t.Errorf("expected a misconfiguration, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"secretpaths/apiauth"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keptChanges is the number of changes callers who reconnect with Last-Event-ID can catch up with.
+const keptChanges = 100
+
+// subscriberBuffer is how many changes a caller may fall behind before its stream is closed,
+// it catches up by reconnecting with Last-Event-ID.
+const subscriberBuffer = 16
+
+// keepAliveInterval is how often an idle stream sends a comment, so proxies do not close it.
+const keepAliveInterval = 30 * time.Second
+
+const (
+	causeCrawl = "crawl"
+	causeEvent = "event"
+)
+
+// change is what changed in the inventory of a cluster, pushed to the callers of /v1/changes.
+type change struct {
+	Id      uint64 `json:"id"`
+	Cluster string `json:"cluster"`
+	Mount   string `json:"mount"`
+	// Cause is crawl if a crawl found the change, or event if Vault notified about it.
+	Cause   string          `json:"cause"`
+	Time    time.Time       `json:"time"`
+	Added   []changedSecret `json:"added,omitempty"`
+	Removed []changedSecret `json:"removed,omitempty"`
+	// Access lists the secrets which other policies have access to than before.
+	Access []changedSecret `json:"access,omitempty"`
+}
+
+// changedSecret is a secret of a change. The names of the policies with access to it now and before
+// are only sent to auditors.
+type changedSecret struct {
+	Path     string   `json:"path"`
+	Policies []string `json:"policies,omitempty"`
+	Previous []string `json:"previous,omitempty"`
+}
+
+// changeFeed fans the changes of the inventories out to the callers of /v1/changes. It keeps the latest changes,
+// so callers who reconnect with Last-Event-ID do not miss any.
+type changeFeed struct {
+	// ctx is done once the server shuts down, which ends the streams.
+	ctx context.Context
+
+	mu          sync.Mutex
+	lastId      uint64
+	recent      []change
+	subscribers map[chan change]struct{}
+}
+
+// subscription receives the changes published after it was made.
+type subscription struct {
+	updates chan change
+	// missed are the kept changes after the id the caller saw last, complete is false if some are not kept anymore.
+	missed   []change
+	complete bool
+	// lastId is the id of the last change published before the subscription.
+	lastId uint64
+}
+
+func newChangeFeed(ctx context.Context) *changeFeed {
+	return &changeFeed{ctx: ctx, subscribers: map[chan change]struct{}{}}
+}
+
+// publish assigns the next id to the change and sends it to every subscriber. Subscribers who fell too far behind
+// are dropped, which ends their streams.
+func (f *changeFeed) publish(update change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastId++
+	update.Id = f.lastId
+	f.recent = append(f.recent, update)
+	if len(f.recent) > keptChanges {
+		f.recent = f.recent[len(f.recent)-keptChanges:]
+	}
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- update:
+		default:
+			delete(f.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// subscribe returns a subscription to the changes, with the kept changes after lastId if it is set.
+func (f *changeFeed) subscribe(lastId uint64) *subscription {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subscribed := &subscription{updates: make(chan change, subscriberBuffer), complete: true, lastId: f.lastId}
+	f.subscribers[subscribed.updates] = struct{}{}
+	if lastId == 0 {
+		return subscribed
+	}
+	for _, update := range f.recent {
+		if update.Id > lastId {
+			subscribed.missed = append(subscribed.missed, update)
+		}
+	}
+	// ids start over when the server restarts, so an id from the future means the caller saw another server
+	subscribed.complete = lastId <= f.lastId && uint64(len(subscribed.missed)) == f.lastId-lastId
+	return subscribed
+}
+
+func (f *changeFeed) unsubscribe(subscribed *subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subscribers[subscribed.updates]; ok {
+		delete(f.subscribers, subscribed.updates)
+		close(subscribed.updates)
+	}
+}
+
+// getChanges streams the changes of the inventories as server-sent events named change, until the caller goes away
+// or the server shuts down. Callers who reconnect with Last-Event-ID get the changes they missed, or a reload event
+// if those are not kept anymore and everything has to be fetched again.
+// With user views, the policies of the caller are looked up once when the stream starts, a caller whose policies
+// change sees the secrets they grant after reconnecting.
+func (f *changeFeed) getChanges(c *gin.Context) {
+	targets, ok := selectClusters(c)
+	if !ok {
+		return
+	}
+	var lastId uint64
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		parsed, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			badRequest(c, fmt.Errorf("Last-Event-ID must be the id of a change, got %q", header))
+			return
+		}
+		lastId = parsed
+	}
+	auditor := c.MustGet("principal").(apiauth.Principal).Role >= apiauth.Auditor
+	// identities maps the name of every cluster which is streamed to the caller, or nil without user views
+	identities := make(map[string]*caller, len(targets))
+	for _, target := range targets {
+		identities[target.name] = nil
+		if userViews(c) {
+			identity, err := lookupCaller(c, target)
+			if err != nil {
+				vaultError(c, err)
+				return
+			}
+			identities[target.name] = &identity
+		}
+	}
+
+	subscribed := f.subscribe(lastId)
+	defer f.unsubscribe(subscribed)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// keeps proxies like nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	send := func(update change) {
+		identity, streamed := identities[update.Cluster]
+		if !streamed {
+			return
+		}
+		if visible, ok := update.visibleTo(auditor, identity); ok {
+			c.Render(-1, sse.Event{Id: strconv.FormatUint(update.Id, 10), Event: "change", Data: visible})
+		}
+	}
+	if !subscribed.complete {
+		c.Render(-1, sse.Event{Id: strconv.FormatUint(subscribed.lastId, 10), Event: "reload",
+			Data: gin.H{"reason": "the changes since the last event are not kept anymore"}})
+	} else {
+		for _, update := range subscribed.missed {
+			send(update)
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case update, open := <-subscribed.updates:
+			if !open {
+				// the caller fell behind and catches up by reconnecting
+				return
+			}
+			send(update)
+		case <-keepAlive.C:
+			_, _ = io.WriteString(c.Writer, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return
+		case <-f.ctx.Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// visibleTo returns the part of the change the caller may see. Only auditors see the policies, and with user views,
+// callers only see the secrets their policies grant access to now or did before.
+func (c change) visibleTo(auditor bool, identity *caller) (change, bool) {
+	filter := func(secrets []changedSecret) []changedSecret {
+		var visible []changedSecret
+		for _, secret := range secrets {
+			if identity != nil && !identity.hasAny(secret.Policies) && !identity.hasAny(secret.Previous) {
+				continue
+			}
+			if !auditor {
+				secret.Policies, secret.Previous = nil, nil
+			}
+			visible = append(visible, secret)
+		}
+		return visible
+	}
+	c.Added, c.Removed, c.Access = filter(c.Added), filter(c.Removed), filter(c.Access)
+	return c, len(c.Added)+len(c.Removed)+len(c.Access) > 0
+}
+
+// publish sends what changed between the inventories of the cluster to the callers of /v1/changes.
+// Nothing is sent for the first crawl, whose previous is nil, callers fetch the whole inventory once it is ready
+// instead of a change adding every secret.
+func (c *cluster) publish(previous, next *snapshot, cause string) {
+	if c.feed == nil || previous == nil {
+		return
+	}
+	update := change{Cluster: c.name, Mount: c.settings.KVEngine, Cause: cause, Time: time.Now()}
+	for path, policies := range next.policies {
+		previousPolicies, existed := previous.policies[path]
+		if !existed {
+			update.Added = append(update.Added, changedSecret{Path: path, Policies: policies})
+		} else if !slices.Equal(previousPolicies, policies) {
+			update.Access = append(update.Access, changedSecret{Path: path, Policies: policies, Previous: previousPolicies})
+		}
+	}
+	for path, previousPolicies := range previous.policies {
+		if _, exists := next.policies[path]; !exists {
+			update.Removed = append(update.Removed, changedSecret{Path: path, Previous: previousPolicies})
+		}
+	}
+	if len(update.Added)+len(update.Removed)+len(update.Access) == 0 {
+		return
+	}
+	for _, secrets := range [][]changedSecret{update.Added, update.Removed, update.Access} {
+		slices.SortFunc(secrets, func(a, b changedSecret) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+	}
+	c.feed.publish(update)
+}
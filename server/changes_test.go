@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"secretpaths/config"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverEvent is a server-sent event as the stream sends it.
+type serverEvent struct {
+	id, name, data string
+}
+
+// stream opens /v1/changes with the headers, given as name and value pairs, and returns the events it sends.
+func stream(t *testing.T, url string, headers ...string) <-chan serverEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/v1/changes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected: %d, got: %d", http.StatusOK, response.StatusCode)
+	}
+	received := make(chan serverEvent, 10)
+	go func() {
+		defer response.Body.Close()
+		var event serverEvent
+		lines := bufio.NewScanner(response.Body)
+		for lines.Scan() {
+			field, value, _ := strings.Cut(lines.Text(), ":")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				event.data = value
+			case "":
+				if event.name != "" {
+					received <- event
+				}
+				event = serverEvent{}
+			}
+		}
+	}()
+	return received
+}
+
+// next returns the next event of the stream, decoding its data into result, or fails after a few seconds.
+func next(t *testing.T, received <-chan serverEvent, result any) serverEvent {
+	t.Helper()
+	select {
+	case event := <-received:
+		if err := json.Unmarshal([]byte(event.data), result); err != nil {
+			t.Fatalf("could not decode %s: %v", event.data, err)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event")
+		return serverEvent{}
+	}
+}
+
+func TestGetChanges(t *testing.T) {
+	vault := newFakeVault(t)
+	target := newTestCluster("primary", vault)
+	server := newTestServer(t, []*cluster{target}, func(settings *config.Config) {
+		settings.API.AnonymousRole = "auditor"
+	})
+
+	crawl(t, target)
+	if target.feed.lastId != 0 {
+		t.Fatalf("expected the first crawl not to publish a change, got %d", target.feed.lastId)
+	}
+	vault.change(func(vault *fakeVault) {
+		vault.secrets = []string{"/team-a/db", "/team-a/api/key", "/team-a/new"}
+	})
+	crawl(t, target)
+	first := target.feed.recent[0]
+	if len(first.Added) != 1 || first.Added[0].Path != "/team-a/new" ||
+		len(first.Removed) != 1 || first.Removed[0].Path != "/team-b/db" {
+		t.Errorf("expected /team-a/new to be added and /team-b/db to be removed, got %+v", first)
+	}
+
+	live := stream(t, server.URL)
+	vault.change(func(vault *fakeVault) {
+		vault.policies["team-b"] = `path "team-a/db" { capabilities = ["read"] }`
+	})
+	crawl(t, target)
+	var update change
+	if event := next(t, live, &update); event.name != "change" || event.id != "2" {
+		t.Fatalf("expected the change with id 2, got %+v", event)
+	}
+	expected := []changedSecret{{Path: "/team-a/db", Policies: []string{"team-a", "team-b"}, Previous: []string{"team-a"}}}
+	if update.Cause != causeCrawl || len(update.Added)+len(update.Removed) != 0 ||
+		!slices.EqualFunc(update.Access, expected, equalSecrets) {
+		t.Errorf("expected the access to /team-a/db to change, got %+v", update)
+	}
+
+	t.Run("catches up", func(t *testing.T) {
+		var missed change
+		event := next(t, stream(t, server.URL, "Last-Event-ID", "1"), &missed)
+		if event.id != "2" || !slices.EqualFunc(missed.Access, expected, equalSecrets) {
+			t.Errorf("expected the change with id 2, got %+v", missed)
+		}
+	})
+	t.Run("reloads", func(t *testing.T) {
+		var reason map[string]string
+		// ids start over when the server restarts
+		event := next(t, stream(t, server.URL, "Last-Event-ID", "7"), &reason)
+		if event.name != "reload" || event.id != "2" || reason["reason"] == "" {
+			t.Errorf("expected a reload event, got %+v", event)
+		}
+	})
+	t.Run("invalid id", func(t *testing.T) {
+		response := call(t, http.MethodGet, server.URL+"/v1/changes", nil, "Last-Event-ID", "latest")
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected: %d, got: %d", http.StatusBadRequest, response.StatusCode)
+		}
+	})
+}
+
+func equalSecrets(a, b changedSecret) bool {
+	return a.Path == b.Path && slices.Equal(a.Policies, b.Policies) && slices.Equal(a.Previous, b.Previous)
+}
+
+func TestChangeFeed_Subscribe(t *testing.T) {
+	feed := newChangeFeed(context.Background())
+	for range keptChanges + 2 {
+		feed.publish(change{Cluster: "primary"})
+	}
+
+	tests := []struct {
+		lastId   uint64
+		complete bool
+		missed   int
+	}{
+		{0, true, 0},
+		{2, true, keptChanges},
+		{keptChanges + 1, true, 1},
+		{keptChanges + 2, true, 0},
+		{1, false, keptChanges},
+		{keptChanges + 3, false, 0},
+	}
+	for _, test := range tests {
+		t.Run(strconv.FormatUint(test.lastId, 10), func(t *testing.T) {
+			subscribed := feed.subscribe(test.lastId)
+			defer feed.unsubscribe(subscribed)
+			if subscribed.complete != test.complete {
+				t.Errorf("expected: %v, got: %v", test.complete, subscribed.complete)
+			}
+			if len(subscribed.missed) != test.missed {
+				t.Fatalf("expected: %d, got: %d missed changes", test.missed, len(subscribed.missed))
+			}
+			if test.missed > 0 && subscribed.missed[0].Id != keptChanges+3-uint64(test.missed) {
+				t.Errorf("expected the missed changes in order, got %d first", subscribed.missed[0].Id)
+			}
+			if subscribed.lastId != keptChanges+2 {
+				t.Errorf("expected: %d, got: %d", keptChanges+2, subscribed.lastId)
+			}
+		})
+	}
+}
+
+func TestChangeFeed_SlowSubscriber(t *testing.T) {
+	feed := newChangeFeed(context.Background())
+	slow, fast := feed.subscribe(0), feed.subscribe(0)
+	defer feed.unsubscribe(fast)
+	for range subscriberBuffer + 1 {
+		feed.publish(change{Cluster: "primary"})
+		<-fast.updates
+	}
+
+	for range subscriberBuffer {
+		<-slow.updates
+	}
+	if _, open := <-slow.updates; open {
+		t.Error("expected the subscriber which fell behind to be dropped")
+	}
+	feed.unsubscribe(slow)
+	feed.publish(change{Cluster: "primary"})
+	if update, open := <-fast.updates; !open || update.Id != subscriberBuffer+2 {
+		t.Errorf("expected the other subscriber to keep receiving changes, got %+v", update)
+	}
+}
+
+func TestChange_VisibleTo(t *testing.T) {
+	update := change{
+		Added:   []changedSecret{{Path: "/team-a/db", Policies: []string{"team-a"}}},
+		Removed: []changedSecret{{Path: "/team-b/db", Previous: []string{"team-b"}}},
+		Access:  []changedSecret{{Path: "/team-c/db", Policies: []string{"team-c"}, Previous: []string{"team-a"}}},
+	}
+	tests := []struct {
+		name     string
+		auditor  bool
+		identity *caller
+		visible  bool
+		paths    []string
+	}{
+		{"auditor", true, nil, true, []string{"/team-a/db", "/team-b/db", "/team-c/db"}},
+		{"viewer", false, nil, true, []string{"/team-a/db", "/team-b/db", "/team-c/db"}},
+		{"user view", false, &caller{Policies: []string{"team-a"}}, true, []string{"/team-a/db", "/team-c/db"}},
+		{"previous access", true, &caller{Policies: []string{"team-b"}}, true, []string{"/team-b/db"}},
+		{"root", false, &caller{Policies: []string{"root"}}, true, []string{"/team-a/db", "/team-b/db", "/team-c/db"}},
+		{"no access", true, &caller{Policies: []string{"default"}}, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			visible, ok := update.visibleTo(test.auditor, test.identity)
+			if ok != test.visible {
+				t.Fatalf("expected: %v, got: %v", test.visible, ok)
+			}
+			var paths []string
+			for _, secrets := range [][]changedSecret{visible.Added, visible.Removed, visible.Access} {
+				for _, secret := range secrets {
+					paths = append(paths, secret.Path)
+					if hasPolicies := secret.Policies != nil || secret.Previous != nil; hasPolicies != test.auditor {
+						t.Errorf("expected the policies of %s only for auditors, got %+v", secret.Path, secret)
+					}
+				}
+			}
+			if !slices.Equal(paths, test.paths) {
+				t.Errorf("expected: %v, got: %v", test.paths, paths)
+			}
+		})
+	}
+	if len(update.Added[0].Policies) != 1 {
+		t.Error("expected the change to stay the same for the other callers")
+	}
+}
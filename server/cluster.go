@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/maypok86/otter"
+	"net/http"
+	"secretpaths/backend"
+	"secretpaths/config"
+	"secretpaths/logging"
+	"secretpaths/metrics"
+	"secretpaths/models"
+	"secretpaths/tracing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cluster is an inventoried Vault or OpenBao server. Every cluster has its own login and cache
+// and is crawled on its own, so a cluster which is down does not affect the others.
+type cluster struct {
+	name     string
+	settings config.Vault
+	schedule config.Schedule
+	manager  *backend.Manager
+	// cache holds lookups and what handlers fetch from Vault before the first crawl finished.
+	cache otter.Cache[string, any]
+	// maxReaders is the number of policies which may read a secret before it counts as overexposed.
+	maxReaders int
+	// inventory is the result of the last successful crawl, nil until the first one finished.
+	// Events update it between the crawls.
+	inventory atomic.Pointer[snapshot]
+	// feed receives what changed whenever the inventory is replaced, it is nil if nobody streams the changes.
+	feed *changeFeed
+
+	// changes orders the updates by events with the crawls. The updates made while a crawl runs are kept
+	// in missed and applied again to its result, which may have been listed before them.
+	changes sync.Mutex
+	crawls  int
+	missed  []func(snapshot) snapshot
+}
+
+// snapshot is the inventory of a cluster found by one crawl. It is replaced as a whole by the next
+// successful crawl and never evicted, so the data does not disappear between crawls however far apart they are.
+type snapshot struct {
+	secrets    []models.AnnotatedSecret
+	graph      models.GraphEntry
+	compressed models.CompressedGraphEntry
+	paths      []models.Secret
+	// policies maps the path of every secret to the names of the policies with access to it.
+	policies map[string][]string
+	// acl are the policies the secrets were annotated with, secrets added by events are annotated with them as well.
+	acl       []models.Policy
+	crawledAt time.Time
+}
+
+func newClusters(settings config.Config) []*cluster {
+	var clusters []*cluster
+	for _, target := range settings.Targets() {
+		connection := target.Connection()
+		counted, traced := metrics.InstrumentVault(target.Name), tracing.InstrumentVault(target.Name)
+		connection.Instrument = func(transport http.RoundTripper) http.RoundTripper {
+			return traced(counted(transport))
+		}
+		cache := newCache(settings.CacheTTL)
+		metrics.RegisterCache(target.Name, cache)
+		clusters = append(clusters, &cluster{
+			name:       target.Name,
+			settings:   target.Vault,
+			schedule:   target.Schedule,
+			manager:    backend.NewManagerWith(connection, target.AuthMethod()),
+			cache:      cache,
+			maxReaders: settings.Metrics.MaxReaders,
+		})
+	}
+	return clusters
+}
+
+// ClusterProvider makes the clusters available to the handlers.
+func ClusterProvider(clusters []*cluster) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("clusters", clusters)
+		c.Next()
+	}
+}
+
+// selectCluster returns the cluster named by the cluster parameter, or the first cluster if it is not set.
+// It responds with 404 if the cluster is unknown.
+func selectCluster(c *gin.Context) (*cluster, bool) {
+	clusters, ok := selectClusters(c)
+	if !ok {
+		return nil, false
+	}
+	return clusters[0], true
+}
+
+// selectClusters returns the cluster named by the cluster parameter, or all clusters if it is not set.
+// It responds with 404 if the cluster is unknown.
+func selectClusters(c *gin.Context) ([]*cluster, bool) {
+	clusters := c.MustGet("clusters").([]*cluster)
+	name := c.Query("cluster")
+	if name == "" {
+		return clusters, true
+	}
+	for _, target := range clusters {
+		if target.name == name {
+			return []*cluster{target}, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown cluster %q", name)})
+	return nil, false
+}
+
+// collect calls get for every cluster and concatenates the results. Clusters which fail are skipped
+// and listed in the X-Unavailable-Clusters header, the error is only returned if every cluster failed.
+func collect[T any](c *gin.Context, clusters []*cluster, get func(context.Context, *cluster) ([]T, error)) ([]T, error) {
+	var items []T
+	var unavailable []string
+	var lastError error
+	for _, target := range clusters {
+		result, err := get(c.Request.Context(), target)
+		if err != nil {
+			lastError = fmt.Errorf("cluster %s: %w", target.name, err)
+			logging.FromContext(c.Request.Context()).Warn("cluster is unavailable", "cluster", target.name, logging.Error(err))
+			unavailable = append(unavailable, target.name)
+			continue
+		}
+		items = append(items, result...)
+	}
+	if len(unavailable) == len(clusters) {
+		return nil, lastError
+	}
+	for _, name := range unavailable {
+		c.Writer.Header().Add("X-Unavailable-Clusters", name)
+	}
+	return items, nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"secretpaths/apiauth"
+	"time"
+)
+
+// API protects the endpoints of secretpaths itself.
+type API struct {
+	// AnonymousRole is the role of callers without credentials, SECRETPATHS_ANONYMOUS_ROLE. If it is empty,
+	// it is viewer while no credentials are configured, so the graph can still be browsed, and none otherwise.
+	// Anonymous callers are only admin, and may start crawls, if it is set to admin.
+	AnonymousRole string   `yaml:"anonymous_role" json:"anonymousRole,omitempty"`
+	APIKeys       []APIKey `yaml:"api_keys" json:"apiKeys,omitempty"`
+	OIDC          APIOIDC  `yaml:"oidc" json:"oidc"`
+	// VaultRoles maps Vault policies to roles, callers sending a Vault token get the highest role of its policies.
+	VaultRoles map[string]string `yaml:"vault_roles" json:"vaultRoles,omitempty"`
+	// CORSOrigins may call the API from a browser, SECRETPATHS_CORS_ORIGINS as a comma separated list.
+	// Without origins, browsers only allow calls from the origin of the API.
+	CORSOrigins []string `yaml:"cors_origins" json:"corsOrigins,omitempty"`
+}
+
+type APIKey struct {
+	Name string `yaml:"name" json:"name"`
+	Key  string `yaml:"key" json:"key"`
+	Role string `yaml:"role" json:"role"`
+}
+
+// APIOIDC accepts tokens of an OpenID Connect provider, e.g. issued to the visualizer.
+type APIOIDC struct {
+	// Issuer of the tokens, SECRETPATHS_OIDC_ISSUER.
+	Issuer string `yaml:"issuer" json:"issuer,omitempty"`
+	// Audience the tokens must be issued for, SECRETPATHS_OIDC_AUDIENCE.
+	Audience string `yaml:"audience" json:"audience,omitempty"`
+	// RolesClaim names the claim mapped to roles, SECRETPATHS_OIDC_ROLES_CLAIM, groups by default.
+	RolesClaim string `yaml:"roles_claim" json:"rolesClaim,omitempty"`
+	// Roles maps the values of the claim to roles.
+	Roles map[string]string `yaml:"roles" json:"roles,omitempty"`
+}
+
+// Secured returns true if any credentials are configured.
+func (a API) Secured() bool {
+	return len(a.APIKeys) > 0 || a.OIDC.Issuer != "" || len(a.VaultRoles) > 0
+}
+
+// Anonymous returns the role of callers without credentials.
+func (a API) Anonymous() apiauth.Role {
+	if a.AnonymousRole == "" {
+		if a.Secured() {
+			return apiauth.None
+		}
+		return apiauth.Viewer
+	}
+	role, _ := apiauth.ParseRole(a.AnonymousRole)
+	return role
+}
+
+// Authenticator checks the configured credentials. lookup resolves Vault tokens to their display name and policies.
+func (a API) Authenticator(lookup func(ctx context.Context, token string) (string, []string, error)) apiauth.Authenticator {
+	var chain apiauth.Chain
+	if len(a.APIKeys) > 0 {
+		keys := make([]apiauth.APIKey, 0, len(a.APIKeys))
+		for _, key := range a.APIKeys {
+			role, _ := apiauth.ParseRole(key.Role)
+			keys = append(keys, apiauth.APIKey{Name: key.Name, Key: key.Key, Role: role})
+		}
+		chain = append(chain, apiauth.NewAPIKeys(keys))
+	}
+	if a.OIDC.Issuer != "" {
+		claim := a.OIDC.RolesClaim
+		if claim == "" {
+			claim = "groups"
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		chain = append(chain, apiauth.NewOIDC(a.OIDC.Issuer, a.OIDC.Audience, claim, roles(a.OIDC.Roles), client))
+	}
+	if len(a.VaultRoles) > 0 {
+		chain = append(chain, apiauth.VaultTokens{Lookup: lookup, Roles: roles(a.VaultRoles)})
+	}
+	return chain
+}
+
+func roles(names map[string]string) map[string]apiauth.Role {
+	mapped := make(map[string]apiauth.Role, len(names))
+	for name, role := range names {
+		mapped[name], _ = apiauth.ParseRole(role)
+	}
+	return mapped
+}
+
+func (a API) validate() []error {
+	var errs []error
+	role := func(setting, name string) {
+		if _, err := apiauth.ParseRole(name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", setting, err))
+		}
+	}
+	if a.AnonymousRole != "" {
+		role("api.anonymous_role", a.AnonymousRole)
+	}
+	keys := make(map[string]bool, len(a.APIKeys))
+	for i, key := range a.APIKeys {
+		if key.Name == "" {
+			errs = append(errs, fmt.Errorf("api.api_keys[%d].name must not be empty", i))
+		}
+		if len(key.Key) < 16 {
+			errs = append(errs, fmt.Errorf("api.api_keys[%d].key must be at least 16 characters long", i))
+		} else if keys[key.Key] {
+			errs = append(errs, fmt.Errorf("api.api_keys[%d].key is used twice", i))
+		}
+		keys[key.Key] = true
+		role(fmt.Sprintf("api.api_keys[%d].role", i), key.Role)
+	}
+	if a.OIDC.Issuer != "" {
+		if issuer, err := url.Parse(a.OIDC.Issuer); err != nil || (issuer.Scheme != "http" && issuer.Scheme != "https") || issuer.Host == "" {
+			errs = append(errs, fmt.Errorf("api.oidc.issuer must be an http or https URL, got %q", a.OIDC.Issuer))
+		}
+		if a.OIDC.Audience == "" {
+			errs = append(errs, errors.New("api.oidc.audience is required with api.oidc.issuer"))
+		}
+	}
+	for value, name := range a.OIDC.Roles {
+		role(fmt.Sprintf("api.oidc.roles[%s]", value), name)
+	}
+	for policy, name := range a.VaultRoles {
+		role(fmt.Sprintf("api.vault_roles[%s]", policy), name)
+	}
+	for i, origin := range a.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if parsed, err := url.Parse(origin); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Path != "" {
+			errs = append(errs, fmt.Errorf("api.cors_origins[%d] must be an origin like https://secretpaths.example.com, got %q", i, origin))
+		}
+	}
+	return errs
+}
+
+func (a API) redacted() API {
+	keys := make([]APIKey, 0, len(a.APIKeys))
+	for _, key := range a.APIKeys {
+		key.Key = redacted
+		keys = append(keys, key)
+	}
+	if len(keys) > 0 {
+		a.APIKeys = keys
+	}
+	return a
+}
@@ -0,0 +1,546 @@
+// Package config loads the settings of the server from a YAML file and the environment.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileVariable names the environment variable pointing to the configuration file.
+const FileVariable = "SECRETPATHS_CONFIG"
+
+// redacted replaces secrets in the configuration shown by /v1/info.
+const redacted = "REDACTED"
+
+// DefaultCluster names the only cluster if no clusters are configured.
+const DefaultCluster = "default"
+
+var clusterName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// environmentReference matches the references to environment variables in the values of the configuration file.
+var environmentReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Config is the configuration of the server. Every setting can be overridden by the environment variable
+// next to it, so deployments configured by the environment keep working without a file.
+type Config struct {
+	// Listen is the address the API listens on, SECRETPATHS_LISTEN.
+	Listen string `yaml:"listen" json:"listen"`
+	// UserViews restricts what callers see to the secrets their own Vault token has access to, SECRETPATHS_USER_VIEWS.
+	UserViews bool `yaml:"user_views" json:"userViews"`
+	// RequestTimeout is how long a request to the API may take, including the requests to Vault it makes,
+	// SECRETPATHS_REQUEST_TIMEOUT.
+	RequestTimeout time.Duration `yaml:"request_timeout" json:"requestTimeout"`
+	// ShutdownTimeout is how long in-flight requests and crawls may take to finish on shutdown, SECRETPATHS_SHUTDOWN_TIMEOUT.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" json:"shutdownTimeout"`
+	// CacheTTL is how long lookups are cached, e.g. the identity behind a token, SECRETPATHS_CACHE_TTL.
+	// The inventory of a crawl is kept until the next crawl replaces it.
+	CacheTTL time.Duration `yaml:"cache_ttl" json:"cacheTtl"`
+	Schedule Schedule      `yaml:"schedule" json:"schedule"`
+	API      API           `yaml:"api" json:"api"`
+	Metrics  Metrics       `yaml:"metrics" json:"metrics"`
+	Tracing  Tracing       `yaml:"tracing" json:"tracing"`
+	Logging  Logging       `yaml:"logging" json:"logging"`
+	Vault    Vault         `yaml:"vault" json:"vault"`
+	// Clusters are inventoried instead of Vault if set. Every cluster inherits the settings of Vault
+	// and overrides them with its own, they are decoded by LoadFile. A cluster with an auth block does not
+	// inherit the auth settings of Vault.
+	Clusters []Cluster `yaml:"-" json:"clusters,omitempty"`
+}
+
+type Metrics struct {
+	// MaxReaders is the number of policies which may grant read access to a secret before it counts as
+	// overexposed, SECRETPATHS_METRICS_MAX_READERS.
+	MaxReaders int `yaml:"max_readers" json:"maxReaders"`
+}
+
+// Tracing configures the export of OpenTelemetry traces. The exporter is configured by the standard
+// OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER variables.
+type Tracing struct {
+	// Exporter is none, otlp or stdout, SECRETPATHS_TRACING_EXPORTER.
+	Exporter string `yaml:"exporter" json:"exporter"`
+}
+
+type Logging struct {
+	// Format is text or json, SECRETPATHS_LOG_FORMAT.
+	Format string `yaml:"format" json:"format"`
+	// Level is debug, info, warn or error, SECRETPATHS_LOG_LEVEL.
+	Level string `yaml:"level" json:"level"`
+	// Paths is how the paths of secrets are logged, plain, hash or redact, SECRETPATHS_LOG_PATHS.
+	// Hashes stay the same across lines, so the lines about a secret can still be correlated.
+	Paths string `yaml:"paths" json:"paths"`
+}
+
+// Cluster is a Vault or OpenBao server with its own address, authentication and mount.
+type Cluster struct {
+	Name     string `yaml:"name" json:"name"`
+	Vault    `yaml:",inline" json:"vault"`
+	Schedule Schedule `yaml:"schedule" json:"schedule"`
+}
+
+type Vault struct {
+	// Address of the Vault server, VAULT_ADDR.
+	Address string `yaml:"address" json:"address"`
+	// KVEngine is the mount of the KV v2 engine which is inventoried, VAULT_KV_ENGINE.
+	KVEngine string `yaml:"kv_engine" json:"kvEngine"`
+	// ReadMetadata reads when every secret was last written, VAULT_READ_METADATA.
+	ReadMetadata bool `yaml:"read_metadata" json:"readMetadata"`
+	// Events subscribes to the events of Vault 1.16 or later and applies them between the crawls, VAULT_EVENTS.
+	Events bool `yaml:"events" json:"events"`
+	Auth   Auth `yaml:"auth" json:"auth"`
+	TLS    TLS  `yaml:"tls" json:"tls"`
+	// OIDCLogin lets callers log in to Vault via /v1/login/oidc to get a token for user views.
+	OIDCLogin OIDCLogin `yaml:"oidc_login" json:"oidcLogin"`
+}
+
+// OIDCLogin is a role of the OIDC auth method of Vault, whose allowed redirect URIs contain RedirectURI.
+type OIDCLogin struct {
+	Role        string `yaml:"role" json:"role,omitempty"`
+	Mount       string `yaml:"mount" json:"mount,omitempty"`
+	RedirectURI string `yaml:"redirect_uri" json:"redirectUri,omitempty"`
+}
+
+type Auth struct {
+	// Method is one of token, token_file, approle, kubernetes, jwt, userpass, ldap or cert, VAULT_AUTH_METHOD.
+	// If it is empty, it is detected from the configured credentials.
+	Method            string        `yaml:"method" json:"method"`
+	Token             string        `yaml:"token" json:"token,omitempty"`
+	TokenFile         string        `yaml:"token_file" json:"tokenFile,omitempty"`
+	TokenFileInterval time.Duration `yaml:"token_file_interval" json:"tokenFileInterval,omitempty"`
+	AppRole           AppRole       `yaml:"approle" json:"approle"`
+	Kubernetes        Kubernetes    `yaml:"kubernetes" json:"kubernetes"`
+	JWT               JWT           `yaml:"jwt" json:"jwt"`
+	Userpass          Credentials   `yaml:"userpass" json:"userpass"`
+	LDAP              Credentials   `yaml:"ldap" json:"ldap"`
+	Cert              Cert          `yaml:"cert" json:"cert"`
+}
+
+type AppRole struct {
+	RoleId   string `yaml:"role_id" json:"roleId,omitempty"`
+	SecretId string `yaml:"secret_id" json:"secretId,omitempty"`
+	Mount    string `yaml:"mount" json:"mount,omitempty"`
+}
+
+type Kubernetes struct {
+	Role      string `yaml:"role" json:"role,omitempty"`
+	TokenPath string `yaml:"token_path" json:"tokenPath,omitempty"`
+	Mount     string `yaml:"mount" json:"mount,omitempty"`
+}
+
+type JWT struct {
+	Role      string `yaml:"role" json:"role,omitempty"`
+	TokenFile string `yaml:"token_file" json:"tokenFile,omitempty"`
+	Mount     string `yaml:"mount" json:"mount,omitempty"`
+}
+
+type Credentials struct {
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password string `yaml:"password" json:"password,omitempty"`
+	Mount    string `yaml:"mount" json:"mount,omitempty"`
+}
+
+type Cert struct {
+	Role  string `yaml:"role" json:"role,omitempty"`
+	Mount string `yaml:"mount" json:"mount,omitempty"`
+}
+
+type TLS struct {
+	CACert     string `yaml:"ca_cert" json:"caCert,omitempty"`
+	CAPath     string `yaml:"ca_path" json:"caPath,omitempty"`
+	ClientCert string `yaml:"client_cert" json:"clientCert,omitempty"`
+	ClientKey  string `yaml:"client_key" json:"clientKey,omitempty"`
+	ServerName string `yaml:"server_name" json:"serverName,omitempty"`
+	SkipVerify bool   `yaml:"skip_verify" json:"skipVerify"`
+}
+
+// Default returns the configuration used if nothing is configured.
+func Default() Config {
+	return Config{
+		Listen:          ":8081",
+		RequestTimeout:  time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		CacheTTL:        5 * time.Minute,
+		Schedule:        Schedule{Interval: 3 * time.Minute},
+		Metrics:         Metrics{MaxReaders: 10},
+		Logging:         Logging{Format: "text", Level: "info", Paths: "plain"},
+		Vault: Vault{
+			Address:  "http://127.0.0.1:8200",
+			KVEngine: "secret",
+		},
+	}
+}
+
+// Load reads the file named by SECRETPATHS_CONFIG, if it is set, applies the environment and validates the result.
+func Load() (Config, error) {
+	return LoadFile(os.Getenv(FileVariable))
+}
+
+// LoadFile reads the file at path on top of the defaults, applies the environment and validates the result.
+// An empty path only uses the environment.
+func LoadFile(path string) (Config, error) {
+	file := struct {
+		Config   `yaml:",inline"`
+		Clusters []yaml.Node `yaml:"clusters"`
+	}{Config: Default()}
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return file.Config, fmt.Errorf("could not read configuration: %w", err)
+		}
+		var document yaml.Node
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return file.Config, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+		if err := expandEnvironment(&document); err != nil {
+			return file.Config, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+		if err := document.Decode(&file); err != nil {
+			return file.Config, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+	}
+	config := file.Config
+	if err := config.applyEnvironment(); err != nil {
+		return config, err
+	}
+	for _, node := range file.Clusters {
+		cluster := Cluster{Vault: config.Vault, Schedule: config.Schedule}
+		if hasKey(node, "auth") {
+			// credentials are not inherited by a cluster with its own login, so they are never sent to another server
+			cluster.Auth = Auth{}
+		}
+		if err := node.Decode(&cluster); err != nil {
+			return config, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+		config.Clusters = append(config.Clusters, cluster)
+	}
+	return config, config.Validate()
+}
+
+// hasKey returns true if the node is a mapping with the key.
+func hasKey(node yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// expandEnvironment replaces the ${NAME} references in the values of the document by the environment variables
+// they name. Keys, comments and a $ without braces are left alone, and a reference to an unset variable is an error,
+// so a typo does not silently configure an empty secret.
+func expandEnvironment(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		var missing []string
+		expanded := environmentReference.ReplaceAllStringFunc(node.Value, func(reference string) string {
+			name := environmentReference.FindStringSubmatch(reference)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			return fmt.Errorf("line %d references %s, which is not set", node.Line, strings.Join(missing, ", "))
+		}
+		if expanded != node.Value && node.Style == 0 {
+			// resolves the type again, so e.g. a port can be set by a variable
+			node.Tag = ""
+		}
+		node.Value = expanded
+		return nil
+	}
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := expandEnvironment(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Targets returns the clusters to inventory, the default cluster configured by Vault if there are none.
+func (c Config) Targets() []Cluster {
+	if len(c.Clusters) == 0 {
+		return []Cluster{{Name: DefaultCluster, Vault: c.Vault, Schedule: c.Schedule}}
+	}
+	return c.Clusters
+}
+
+// applyEnvironment overrides the settings whose environment variables are set.
+func (c *Config) applyEnvironment() error {
+	variables := map[string]*string{
+		"SECRETPATHS_LISTEN":           &c.Listen,
+		"SECRETPATHS_ANONYMOUS_ROLE":   &c.API.AnonymousRole,
+		"SECRETPATHS_OIDC_ISSUER":      &c.API.OIDC.Issuer,
+		"SECRETPATHS_OIDC_AUDIENCE":    &c.API.OIDC.Audience,
+		"SECRETPATHS_OIDC_ROLES_CLAIM": &c.API.OIDC.RolesClaim,
+		"SECRETPATHS_TRACING_EXPORTER": &c.Tracing.Exporter,
+		"SECRETPATHS_LOG_FORMAT":       &c.Logging.Format,
+		"SECRETPATHS_LOG_LEVEL":        &c.Logging.Level,
+		"SECRETPATHS_LOG_PATHS":        &c.Logging.Paths,
+		"SECRETPATHS_SCHEDULE_CRON":    &c.Schedule.Cron,
+		"VAULT_ADDR":                   &c.Vault.Address,
+		"VAULT_KV_ENGINE":              &c.Vault.KVEngine,
+		"VAULT_AUTH_METHOD":            &c.Vault.Auth.Method,
+		"VAULT_TOKEN":                  &c.Vault.Auth.Token,
+		"VAULT_TOKEN_FILE":             &c.Vault.Auth.TokenFile,
+		"APPROLE_ROLE_ID":              &c.Vault.Auth.AppRole.RoleId,
+		"APPROLE_SECRET_ID":            &c.Vault.Auth.AppRole.SecretId,
+		"APPROLE_PATH":                 &c.Vault.Auth.AppRole.Mount,
+		"KUBERNETES_ROLE":              &c.Vault.Auth.Kubernetes.Role,
+		"KUBERNETES_TOKEN_PATH":        &c.Vault.Auth.Kubernetes.TokenPath,
+		"KUBERNETES_PATH":              &c.Vault.Auth.Kubernetes.Mount,
+		"JWT_ROLE":                     &c.Vault.Auth.JWT.Role,
+		"JWT_TOKEN_FILE":               &c.Vault.Auth.JWT.TokenFile,
+		"JWT_PATH":                     &c.Vault.Auth.JWT.Mount,
+		"USERPASS_USERNAME":            &c.Vault.Auth.Userpass.Username,
+		"USERPASS_PASSWORD":            &c.Vault.Auth.Userpass.Password,
+		"USERPASS_PATH":                &c.Vault.Auth.Userpass.Mount,
+		"LDAP_USERNAME":                &c.Vault.Auth.LDAP.Username,
+		"LDAP_PASSWORD":                &c.Vault.Auth.LDAP.Password,
+		"LDAP_PATH":                    &c.Vault.Auth.LDAP.Mount,
+		"CERT_ROLE":                    &c.Vault.Auth.Cert.Role,
+		"CERT_PATH":                    &c.Vault.Auth.Cert.Mount,
+		"VAULT_CACERT":                 &c.Vault.TLS.CACert,
+		"VAULT_CAPATH":                 &c.Vault.TLS.CAPath,
+		"VAULT_CLIENT_CERT":            &c.Vault.TLS.ClientCert,
+		"VAULT_CLIENT_KEY":             &c.Vault.TLS.ClientKey,
+		"VAULT_TLS_SERVER_NAME":        &c.Vault.TLS.ServerName,
+		"OIDC_LOGIN_ROLE":              &c.Vault.OIDCLogin.Role,
+		"OIDC_LOGIN_PATH":              &c.Vault.OIDCLogin.Mount,
+		"OIDC_LOGIN_REDIRECT_URI":      &c.Vault.OIDCLogin.RedirectURI,
+	}
+	for key, setting := range variables {
+		if value, ok := os.LookupEnv(key); ok && value != "" {
+			*setting = value
+		}
+	}
+	booleans := map[string]*bool{
+		"SECRETPATHS_USER_VIEWS": &c.UserViews,
+		"VAULT_READ_METADATA":    &c.Vault.ReadMetadata,
+		"VAULT_EVENTS":           &c.Vault.Events,
+		"VAULT_SKIP_VERIFY":      &c.Vault.TLS.SkipVerify,
+	}
+	for key, setting := range booleans {
+		if value := os.Getenv(key); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s is not a boolean: %q", key, value)
+			}
+			*setting = parsed
+		}
+	}
+	if value := os.Getenv("SECRETPATHS_CORS_ORIGINS"); value != "" {
+		c.API.CORSOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.API.CORSOrigins = append(c.API.CORSOrigins, origin)
+			}
+		}
+	}
+	if value := os.Getenv("SECRETPATHS_SCHEDULE_WINDOWS"); value != "" {
+		c.Schedule.Windows = nil
+		for _, window := range strings.Split(value, ",") {
+			if window = strings.TrimSpace(window); window != "" {
+				c.Schedule.Windows = append(c.Schedule.Windows, window)
+			}
+		}
+	}
+	if value := os.Getenv("SECRETPATHS_METRICS_MAX_READERS"); value != "" {
+		maxReaders, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("SECRETPATHS_METRICS_MAX_READERS is not a number: %q", value)
+		}
+		c.Metrics.MaxReaders = maxReaders
+	}
+	durations := map[string]*time.Duration{
+		"SECRETPATHS_REQUEST_TIMEOUT":   &c.RequestTimeout,
+		"SECRETPATHS_SHUTDOWN_TIMEOUT":  &c.ShutdownTimeout,
+		"SECRETPATHS_CACHE_TTL":         &c.CacheTTL,
+		"SECRETPATHS_SCHEDULE_INTERVAL": &c.Schedule.Interval,
+		"SECRETPATHS_SCHEDULE_JITTER":   &c.Schedule.Jitter,
+		"VAULT_TOKEN_FILE_INTERVAL":     &c.Vault.Auth.TokenFileInterval,
+	}
+	for key, setting := range durations {
+		if value := os.Getenv(key); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s is not a duration: %q", key, value)
+			}
+			*setting = parsed
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, so a broken configuration can be fixed in one go.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen must not be empty"))
+	}
+	if c.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("request_timeout must be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if c.CacheTTL <= 0 {
+		errs = append(errs, errors.New("cache_ttl must be positive"))
+	}
+	errs = append(errs, c.API.validate()...)
+	if c.Metrics.MaxReaders < 0 {
+		errs = append(errs, errors.New("metrics.max_readers must not be negative"))
+	}
+	switch c.Tracing.Exporter {
+	case "", "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, otlp or stdout, got %q", c.Tracing.Exporter))
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		errs = append(errs, fmt.Errorf("logging.format must be text or json, got %q", c.Logging.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level))
+	}
+	if c.Logging.Paths != "plain" && c.Logging.Paths != "hash" && c.Logging.Paths != "redact" {
+		errs = append(errs, fmt.Errorf("logging.paths must be plain, hash or redact, got %q", c.Logging.Paths))
+	}
+	if len(c.Clusters) == 0 {
+		errs = append(errs, c.Schedule.validate("schedule")...)
+		return errors.Join(append(errs, c.Vault.validate("vault")...)...)
+	}
+	names := make(map[string]bool, len(c.Clusters))
+	for i, cluster := range c.Clusters {
+		if !clusterName.MatchString(cluster.Name) {
+			errs = append(errs, fmt.Errorf("clusters[%d].name must be lower case letters, digits, - and _, got %q", i, cluster.Name))
+		} else if names[cluster.Name] {
+			errs = append(errs, fmt.Errorf("clusters[%d].name %q is used twice", i, cluster.Name))
+		}
+		names[cluster.Name] = true
+		errs = append(errs, cluster.validate(fmt.Sprintf("clusters[%d]", i))...)
+		errs = append(errs, cluster.Schedule.validate(fmt.Sprintf("clusters[%d].schedule", i))...)
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of one Vault, prefix names them in errors.
+func (v Vault) validate(prefix string) []error {
+	var errs []error
+	if address, err := url.Parse(v.Address); err != nil || (address.Scheme != "http" && address.Scheme != "https") || address.Host == "" {
+		errs = append(errs, fmt.Errorf("%s.address must be an http or https URL, got %q", prefix, v.Address))
+	}
+	if v.KVEngine == "" {
+		errs = append(errs, fmt.Errorf("%s.kv_engine must not be empty", prefix))
+	}
+	if (v.TLS.ClientCert == "") != (v.TLS.ClientKey == "") {
+		errs = append(errs, fmt.Errorf("%s.tls.client_cert and %s.tls.client_key must be set together", prefix, prefix))
+	}
+	if v.Auth.TokenFileInterval < 0 {
+		errs = append(errs, fmt.Errorf("%s.auth.token_file_interval must not be negative", prefix))
+	}
+	if v.OIDCLogin.Role != "" {
+		if uri, err := url.Parse(v.OIDCLogin.RedirectURI); err != nil || !uri.IsAbs() {
+			errs = append(errs, fmt.Errorf("%s.oidc_login.redirect_uri must be an absolute URL, got %q", prefix, v.OIDCLogin.RedirectURI))
+		}
+	}
+	return append(errs, v.Auth.validate(prefix, v.TLS)...)
+}
+
+// DetectedMethod returns the configured method, or if there is none, the first of kubernetes, approle
+// and token that has credentials.
+func (a Auth) DetectedMethod() string {
+	switch {
+	case a.Method != "":
+		return a.Method
+	case a.Kubernetes.Role != "":
+		return "kubernetes"
+	case a.AppRole.RoleId != "" || a.AppRole.SecretId != "":
+		return "approle"
+	case a.Token != "":
+		return "token"
+	}
+	return ""
+}
+
+func (a Auth) validate(prefix string, tls TLS) []error {
+	// required takes pairs of setting names and values
+	required := func(settings ...string) []error {
+		var errs []error
+		for i := 0; i < len(settings); i += 2 {
+			if settings[i+1] == "" {
+				errs = append(errs, fmt.Errorf("%s.auth.%s is required for the %s method", prefix, settings[i], a.DetectedMethod()))
+			}
+		}
+		return errs
+	}
+	switch a.DetectedMethod() {
+	case "":
+		return []error{fmt.Errorf("no authentication method found, set %s.auth.method or the credentials of kubernetes, approle or token", prefix)}
+	case "token":
+		return required("token", a.Token)
+	case "token_file":
+		return required("token_file", a.TokenFile)
+	case "approle":
+		return required("approle.role_id", a.AppRole.RoleId, "approle.secret_id", a.AppRole.SecretId)
+	case "kubernetes":
+		return required("kubernetes.role", a.Kubernetes.Role)
+	case "jwt":
+		return required("jwt.token_file", a.JWT.TokenFile)
+	case "userpass":
+		return required("userpass.username", a.Userpass.Username, "userpass.password", a.Userpass.Password)
+	case "ldap":
+		return required("ldap.username", a.LDAP.Username, "ldap.password", a.LDAP.Password)
+	case "cert":
+		if tls.ClientCert == "" {
+			return []error{fmt.Errorf("%s.tls.client_cert and %s.tls.client_key are required for the cert method", prefix, prefix)}
+		}
+		return nil
+	}
+	return []error{fmt.Errorf("%s.auth.method %q is unknown", prefix, a.Method)}
+}
+
+// Redacted returns a copy without passwords, secret IDs, tokens and API keys, which is safe to show.
+func (c Config) Redacted() Config {
+	c.API = c.API.redacted()
+	c.Vault = c.Vault.redacted()
+	clusters := make([]Cluster, 0, len(c.Clusters))
+	for _, cluster := range c.Clusters {
+		cluster.Vault = cluster.Vault.redacted()
+		clusters = append(clusters, cluster)
+	}
+	if len(clusters) > 0 {
+		c.Clusters = clusters
+	}
+	return c
+}
+
+func (v Vault) redacted() Vault {
+	redact := func(value *string) {
+		if *value != "" {
+			*value = redacted
+		}
+	}
+	redact(&v.Auth.Token)
+	redact(&v.Auth.AppRole.SecretId)
+	redact(&v.Auth.Userpass.Password)
+	redact(&v.Auth.LDAP.Password)
+	return v
+}
@@ -0,0 +1,407 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"secretpaths/apiauth"
+	"secretpaths/backend"
+	"secretpaths/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "secretpaths.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+listen: ":9000"
+vault:
+  address: https://vault.example.com:8200
+  kv_engine: kv
+  auth:
+    method: approle
+    token_file_interval: 30s
+    approle:
+      role_id: role
+      secret_id: secret
+  tls:
+    server_name: vault.internal
+`)
+	t.Setenv("VAULT_KV_ENGINE", "team")
+	t.Setenv("VAULT_READ_METADATA", "true")
+	t.Setenv("VAULT_EVENTS", "true")
+
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Listen != ":9000" || settings.Vault.Address != "https://vault.example.com:8200" || settings.Vault.TLS.ServerName != "vault.internal" {
+		t.Errorf("expected the settings of the file, got %+v", settings)
+	}
+	if settings.Vault.KVEngine != "team" || !settings.Vault.ReadMetadata || !settings.Vault.Events {
+		t.Errorf("expected the environment to override the file, got %+v", settings.Vault)
+	}
+	if settings.Vault.Auth.TokenFileInterval != 30*time.Second {
+		t.Errorf("expected: %v, got: %v", 30*time.Second, settings.Vault.Auth.TokenFileInterval)
+	}
+	method, ok := settings.Vault.AuthMethod().(backend.AppRoleAuth)
+	if !ok || method.RoleId != "role" || method.SecretId != "secret" {
+		t.Errorf("expected an approle login, got %#v", settings.Vault.AuthMethod())
+	}
+}
+
+func TestLoadFile_Environment(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "s.static")
+
+	settings, err := config.LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Listen != ":8081" || settings.Vault.Address != "http://127.0.0.1:8200" || settings.Vault.KVEngine != "secret" {
+		t.Errorf("expected the defaults, got %+v", settings)
+	}
+	if _, ok := settings.Vault.AuthMethod().(backend.TokenAuth); !ok {
+		t.Errorf("expected a token login, got %#v", settings.Vault.AuthMethod())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  address: vault.example.com
+  kv_engine: ""
+  auth:
+    method: userpass
+    userpass:
+      username: alice
+  tls:
+    client_cert: client.pem
+`)
+	_, err := config.LoadFile(path)
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+	for _, expected := range []string{"vault.address", "vault.kv_engine", "vault.tls.client_cert", "vault.auth.userpass.password"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %s to be reported, got %v", expected, err)
+		}
+	}
+
+	t.Run("unknown method", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "github")
+		if _, err := config.LoadFile(""); err == nil || !strings.Contains(err.Error(), "github") {
+			t.Errorf("expected the unknown method to be reported, got %v", err)
+		}
+	})
+	t.Run("oidc login", func(t *testing.T) {
+		t.Setenv("VAULT_TOKEN", "s.static")
+		t.Setenv("OIDC_LOGIN_ROLE", "reader")
+		t.Setenv("OIDC_LOGIN_REDIRECT_URI", "/v1/login/oidc/callback")
+		if _, err := config.LoadFile(""); err == nil || !strings.Contains(err.Error(), "vault.oidc_login.redirect_uri") {
+			t.Errorf("expected the relative redirect uri to be reported, got %v", err)
+		}
+	})
+	t.Run("invalid yaml", func(t *testing.T) {
+		if _, err := config.LoadFile(writeConfig(t, "vault: [")); err == nil {
+			t.Error("expected a parse error")
+		}
+	})
+}
+
+func TestRedacted(t *testing.T) {
+	settings := config.Default()
+	settings.Vault.Auth.Token = "s.static"
+	settings.Vault.Auth.AppRole = config.AppRole{RoleId: "role", SecretId: "secret"}
+	settings.Vault.Auth.LDAP.Password = "password"
+
+	redacted := settings.Redacted()
+	if redacted.Vault.Auth.Token != "REDACTED" || redacted.Vault.Auth.AppRole.SecretId != "REDACTED" || redacted.Vault.Auth.LDAP.Password != "REDACTED" {
+		t.Errorf("expected the secrets to be redacted, got %+v", redacted.Vault.Auth)
+	}
+	if redacted.Vault.Auth.AppRole.RoleId != "role" || redacted.Vault.Auth.Userpass.Password != "" {
+		t.Errorf("expected other settings to be kept, got %+v", redacted.Vault.Auth)
+	}
+	if settings.Vault.Auth.Token != "s.static" {
+		t.Error("expected the original configuration to be unchanged")
+	}
+}
+
+func TestLoadFile_Clusters(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  kv_engine: kv
+  auth:
+    kubernetes:
+      role: secretpaths
+clusters:
+  - name: eu
+    address: https://vault.eu.example.com
+  - name: openbao
+    address: https://openbao.example.com
+    kv_engine: secret
+    auth:
+      approle:
+        role_id: role
+        secret_id: ${TEST_SECRET_ID}
+`)
+	t.Setenv("TEST_SECRET_ID", "secret")
+	t.Setenv("VAULT_TOKEN", "s.static")
+
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := settings.Targets()
+	if len(targets) != 2 || targets[0].Name != "eu" || targets[1].Name != "openbao" {
+		t.Fatalf("expected the clusters eu and openbao, got %+v", targets)
+	}
+	if targets[0].KVEngine != "kv" || targets[0].Address != "https://vault.eu.example.com" {
+		t.Errorf("expected eu to inherit the mount, got %+v", targets[0].Vault)
+	}
+	if _, ok := targets[0].AuthMethod().(backend.KubernetesAuth); !ok {
+		t.Errorf("expected eu to inherit the kubernetes login, got %#v", targets[0].AuthMethod())
+	}
+	if targets[1].KVEngine != "secret" || targets[1].Auth.AppRole.SecretId != "secret" {
+		t.Errorf("expected openbao to override the settings, got %+v", targets[1].Vault)
+	}
+	if _, ok := targets[1].AuthMethod().(backend.AppRoleAuth); !ok || targets[1].Auth.Token != "" || targets[1].Auth.Kubernetes.Role != "" {
+		t.Errorf("expected openbao to log in with its own approle only, got %+v", targets[1].Auth)
+	}
+	if redacted := settings.Redacted(); redacted.Clusters[1].Auth.AppRole.SecretId != "REDACTED" || settings.Clusters[1].Auth.AppRole.SecretId != "secret" {
+		t.Error("expected the secrets of the clusters to be redacted in a copy")
+	}
+}
+
+func TestLoadFile_DefaultCluster(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "s.static")
+
+	settings, err := config.LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targets := settings.Targets(); len(targets) != 1 || targets[0].Name != config.DefaultCluster {
+		t.Errorf("expected only the default cluster, got %+v", targets)
+	}
+}
+
+func TestValidate_Clusters(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  auth:
+    token: s.static
+clusters:
+  - name: eu
+  - name: eu
+  - name: US East
+    address: ""
+`)
+	_, err := config.LoadFile(path)
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+	for _, expected := range []string{"clusters[1].name \"eu\" is used twice", "clusters[2].name", "clusters[2].address"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %s to be reported, got %v", expected, err)
+		}
+	}
+}
+
+func TestLoadFile_API(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  auth:
+    token: s.static
+api:
+  api_keys:
+    - name: ci
+      key: ${TEST_API_KEY}
+      role: admin
+  vault_roles:
+    secretpaths-auditors: auditor
+  cors_origins: [https://secretpaths.example.com]
+`)
+	t.Setenv("TEST_API_KEY", "ci-key-0123456789")
+	t.Setenv("SECRETPATHS_OIDC_ISSUER", "https://login.example.com")
+	t.Setenv("SECRETPATHS_OIDC_AUDIENCE", "secretpaths")
+
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.API.Secured() || settings.API.Anonymous() != apiauth.None {
+		t.Errorf("expected anonymous callers to have no role, got %v", settings.API.Anonymous())
+	}
+	if settings.API.OIDC.Issuer != "https://login.example.com" || settings.API.APIKeys[0].Key != "ci-key-0123456789" {
+		t.Errorf("expected the file and the environment to be applied, got %+v", settings.API)
+	}
+	if redacted := settings.Redacted(); redacted.API.APIKeys[0].Key != "REDACTED" || settings.API.APIKeys[0].Key == "REDACTED" {
+		t.Error("expected the api keys to be redacted in a copy")
+	}
+
+	t.Run("cors origins", func(t *testing.T) {
+		t.Setenv("SECRETPATHS_CORS_ORIGINS", "http://localhost:5173, https://secretpaths.example.com")
+		settings, err := config.LoadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(settings.API.CORSOrigins) != 2 || settings.API.CORSOrigins[0] != "http://localhost:5173" {
+			t.Errorf("expected the origins of the environment, got %v", settings.API.CORSOrigins)
+		}
+	})
+}
+
+func TestAPI_Unsecured(t *testing.T) {
+	settings := config.Default()
+	if settings.API.Secured() || settings.API.Anonymous() != apiauth.Viewer {
+		t.Errorf("expected every caller to be viewer without credentials, got %v", settings.API.Anonymous())
+	}
+	settings.API.AnonymousRole = "admin"
+	if settings.API.Anonymous() != apiauth.Admin {
+		t.Errorf("expected: %v, got: %v", apiauth.Admin, settings.API.Anonymous())
+	}
+}
+
+func TestValidate_API(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  auth:
+    token: s.static
+api:
+  anonymous_role: guest
+  api_keys:
+    - name: ci
+      key: short
+      role: admin
+  oidc:
+    issuer: https://login.example.com
+  cors_origins: [https://secretpaths.example.com/visualizer]
+`)
+	_, err := config.LoadFile(path)
+	if err == nil {
+		t.Fatal("expected the configuration to be invalid")
+	}
+	for _, expected := range []string{"api.anonymous_role", "api.api_keys[0].key", "api.oidc.audience", "api.cors_origins[0]"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %s to be reported, got %v", expected, err)
+		}
+	}
+}
+
+func TestLoadFile_Metrics(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  auth:
+    token: s.static
+`)
+	t.Setenv("SECRETPATHS_METRICS_MAX_READERS", "3")
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Metrics.MaxReaders != 3 {
+		t.Errorf("expected max_readers to be read from the environment, got %d", settings.Metrics.MaxReaders)
+	}
+
+	t.Setenv("SECRETPATHS_METRICS_MAX_READERS", "-1")
+	if _, err := config.LoadFile(path); err == nil || !strings.Contains(err.Error(), "metrics.max_readers") {
+		t.Errorf("expected a negative max_readers to be invalid, got %v", err)
+	}
+}
+
+func TestLoadFile_Logging(t *testing.T) {
+	path := writeConfig(t, `
+vault:
+  auth:
+    token: s.static
+logging:
+  format: json
+  level: debug
+`)
+	t.Setenv("SECRETPATHS_LOG_PATHS", "hash")
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Logging != (config.Logging{Format: "json", Level: "debug", Paths: "hash"}) {
+		t.Errorf("expected the logging settings of the file and the environment, got %+v", settings.Logging)
+	}
+
+	t.Setenv("SECRETPATHS_LOG_FORMAT", "xml")
+	t.Setenv("SECRETPATHS_LOG_LEVEL", "verbose")
+	t.Setenv("SECRETPATHS_LOG_PATHS", "encrypt")
+	_, err = config.LoadFile(path)
+	for _, expected := range []string{"logging.format", "logging.level", "logging.paths"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %s to be reported, got %v", expected, err)
+		}
+	}
+}
+
+func TestLoadFile_Timeouts(t *testing.T) {
+	path := writeConfig(t, `
+request_timeout: 10s
+vault:
+  auth:
+    token: s.static
+`)
+	t.Setenv("SECRETPATHS_SHUTDOWN_TIMEOUT", "1m")
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.RequestTimeout != 10*time.Second || settings.ShutdownTimeout != time.Minute {
+		t.Errorf("expected the timeouts of the file and the environment, got %v and %v", settings.RequestTimeout, settings.ShutdownTimeout)
+	}
+
+	t.Setenv("SECRETPATHS_SHUTDOWN_TIMEOUT", "soon")
+	if _, err := config.LoadFile(path); err == nil || !strings.Contains(err.Error(), "SECRETPATHS_SHUTDOWN_TIMEOUT") {
+		t.Errorf("expected the invalid duration to be reported, got %v", err)
+	}
+	t.Setenv("SECRETPATHS_SHUTDOWN_TIMEOUT", "0s")
+	if _, err := config.LoadFile(path); err == nil || !strings.Contains(err.Error(), "shutdown_timeout") {
+		t.Errorf("expected a zero timeout to be invalid, got %v", err)
+	}
+}
+
+func TestLoadFile_EnvironmentReferences(t *testing.T) {
+	path := writeConfig(t, `
+# comments like $HOME or ${UNSET_IN_A_COMMENT} are not expanded
+listen: ":${TEST_PORT}"
+vault:
+  kv_engine: ${TEST_MOUNT}
+  auth:
+    method: approle
+    approle:
+      role_id: role$1
+      secret_id: "${TEST_SECRET_ID}"
+`)
+	t.Setenv("TEST_PORT", "9000")
+	t.Setenv("TEST_MOUNT", "team")
+	t.Setenv("TEST_SECRET_ID", "p$ss: ${word}")
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Listen != ":9000" || settings.Vault.KVEngine != "team" {
+		t.Errorf("expected the references to be expanded, got %+v", settings)
+	}
+	if settings.Vault.Auth.AppRole.RoleId != "role$1" || settings.Vault.Auth.AppRole.SecretId != "p$ss: ${word}" {
+		t.Errorf("expected the values to be kept as they are, got %+v", settings.Vault.Auth.AppRole)
+	}
+
+	path = writeConfig(t, `
+vault:
+  auth:
+    token: ${TEST_UNSET_TOKEN}
+`)
+	if _, err := config.LoadFile(path); err == nil || !strings.Contains(err.Error(), "TEST_UNSET_TOKEN") {
+		t.Errorf("expected the unset variable to be reported, got %v", err)
+	}
+}
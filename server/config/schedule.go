@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"strings"
+	"time"
+)
+
+// Schedule configures when a cluster is crawled. Clusters inherit the schedule of the configuration
+// and override it with their own.
+type Schedule struct {
+	// Interval is the time between crawls, SECRETPATHS_SCHEDULE_INTERVAL.
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// Cron is a cron expression with five fields which replaces Interval, e.g. "0 * * * *", SECRETPATHS_SCHEDULE_CRON.
+	Cron string `yaml:"cron" json:"cron,omitempty"`
+	// Jitter delays every crawl by a random duration up to it, so clusters sharing a schedule are not all
+	// crawled at the same time, SECRETPATHS_SCHEDULE_JITTER.
+	Jitter time.Duration `yaml:"jitter" json:"jitter"`
+	// Windows restrict the crawls to times of the day in the local time zone, e.g. 22:00-06:00 for off-hours,
+	// SECRETPATHS_SCHEDULE_WINDOWS. A window wraps around midnight if it ends before it starts.
+	Windows []string `yaml:"windows" json:"windows,omitempty"`
+}
+
+// Allows returns true if t is within one of the windows, or if there are none.
+func (s Schedule) Allows(t time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	now := t.Sub(midnight)
+	for _, value := range s.Windows {
+		start, end, err := parseWindow(value)
+		if err != nil {
+			continue
+		}
+		if start <= end && now >= start && now < end {
+			return true
+		}
+		if start > end && (now >= start || now < end) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) validate(prefix string) []error {
+	var errs []error
+	if s.Cron != "" {
+		if _, err := cron.ParseStandard(s.Cron); err != nil {
+			errs = append(errs, fmt.Errorf("%s.cron is invalid: %w", prefix, err))
+		}
+	} else if s.Interval < time.Minute {
+		errs = append(errs, fmt.Errorf("%s.interval must be at least a minute, got %v", prefix, s.Interval))
+	}
+	if s.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("%s.jitter must not be negative", prefix))
+	}
+	for i, value := range s.Windows {
+		if _, _, err := parseWindow(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s.windows[%d] %w", prefix, i, err))
+		}
+	}
+	return errs
+}
+
+// parseWindow returns the start and end of a window like 22:00-06:00 as the time since midnight.
+func parseWindow(value string) (start, end time.Duration, err error) {
+	from, to, ok := strings.Cut(value, "-")
+	if ok {
+		start, err = parseTimeOfDay(from)
+	}
+	if ok && err == nil {
+		end, err = parseTimeOfDay(to)
+	}
+	if !ok || err != nil || start == end {
+		return 0, 0, fmt.Errorf("must be two different times of the day like 22:00-06:00, got %q", value)
+	}
+	return start, end, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
@@ -0,0 +1,84 @@
+package config_test
+
+import (
+	"secretpaths/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Allows(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 5, 2, parsed.Hour(), parsed.Minute(), 0, 0, time.Local)
+	}
+	tests := []struct {
+		windows []string
+		clock   string
+		allowed bool
+	}{
+		{nil, "12:00", true},
+		{[]string{"22:00-06:00"}, "23:30", true},
+		{[]string{"22:00-06:00"}, "05:59", true},
+		{[]string{"22:00-06:00"}, "06:00", false},
+		{[]string{"22:00-06:00"}, "12:00", false},
+		{[]string{"12:00-13:00", "18:00-24:00"}, "12:30", true},
+		{[]string{"12:00-13:00", "18:00-24:00"}, "23:59", true},
+		{[]string{"12:00-13:00", "18:00-24:00"}, "14:00", false},
+	}
+	for _, test := range tests {
+		schedule := config.Schedule{Interval: time.Hour, Windows: test.windows}
+		if allowed := schedule.Allows(at(test.clock)); allowed != test.allowed {
+			t.Errorf("%v at %s: expected: %v, got: %v", test.windows, test.clock, test.allowed, allowed)
+		}
+	}
+}
+
+func TestLoadFile_Schedule(t *testing.T) {
+	path := writeConfig(t, `
+schedule:
+  interval: 10m
+  jitter: 1m
+vault:
+  auth:
+    token: s.static
+clusters:
+  - name: primary
+  - name: dr
+    schedule:
+      cron: "0 2 * * *"
+      windows: ["01:00-05:00"]
+`)
+	settings, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, dr := settings.Targets()[0].Schedule, settings.Targets()[1].Schedule
+	if primary.Interval != 10*time.Minute || primary.Jitter != time.Minute || primary.Cron != "" {
+		t.Errorf("expected the cluster to inherit the schedule, got %+v", primary)
+	}
+	if dr.Cron != "0 2 * * *" || dr.Jitter != time.Minute || len(dr.Windows) != 1 {
+		t.Errorf("expected the cluster to override the schedule, got %+v", dr)
+	}
+
+	t.Setenv("SECRETPATHS_SCHEDULE_INTERVAL", "30s")
+	t.Setenv("SECRETPATHS_SCHEDULE_WINDOWS", "22:00-06:00, 25:00-26:00")
+	_, err = config.LoadFile(writeConfig(t, `
+vault:
+  auth:
+    token: s.static
+`))
+	for _, expected := range []string{"schedule.interval", "schedule.windows[1]"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %s to be reported, got %v", expected, err)
+		}
+	}
+
+	// the environment applies to the schedule the clusters inherit, dr keeps its own cron expression
+	t.Setenv("SECRETPATHS_SCHEDULE_WINDOWS", "")
+	t.Setenv("SECRETPATHS_SCHEDULE_CRON", "every day")
+	_, err = config.LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "clusters[0].schedule.cron") || strings.Contains(err.Error(), "clusters[1]") {
+		t.Errorf("expected the invalid cron expression of primary to be reported, got %v", err)
+	}
+}
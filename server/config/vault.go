@@ -0,0 +1,42 @@
+package config
+
+import (
+	"secretpaths/backend"
+)
+
+// Connection returns how the backend reaches Vault.
+func (v Vault) Connection() backend.Connection {
+	return backend.Connection{
+		Address: v.Address,
+		TLS: backend.TLSConfig{
+			CACert:     v.TLS.CACert,
+			CAPath:     v.TLS.CAPath,
+			ClientCert: v.TLS.ClientCert,
+			ClientKey:  v.TLS.ClientKey,
+			ServerName: v.TLS.ServerName,
+			SkipVerify: v.TLS.SkipVerify,
+		},
+	}
+}
+
+// AuthMethod returns the authentication method of the backend, the configuration must be valid.
+func (v Vault) AuthMethod() backend.AuthMethod {
+	auth := v.Auth
+	switch auth.DetectedMethod() {
+	case "token_file":
+		return backend.TokenFileAuth{Path: auth.TokenFile, Interval: auth.TokenFileInterval}
+	case "approle":
+		return backend.AppRoleAuth{RoleId: auth.AppRole.RoleId, SecretId: auth.AppRole.SecretId, Mount: auth.AppRole.Mount}
+	case "kubernetes":
+		return backend.KubernetesAuth{Role: auth.Kubernetes.Role, TokenPath: auth.Kubernetes.TokenPath, Mount: auth.Kubernetes.Mount}
+	case "jwt":
+		return backend.JWTAuth{Role: auth.JWT.Role, Path: auth.JWT.TokenFile, Mount: auth.JWT.Mount}
+	case "userpass":
+		return backend.UserpassAuth{Username: auth.Userpass.Username, Password: auth.Userpass.Password, Mount: auth.Userpass.Mount}
+	case "ldap":
+		return backend.LDAPAuth{Username: auth.LDAP.Username, Password: auth.LDAP.Password, Mount: auth.LDAP.Mount}
+	case "cert":
+		return backend.CertAuth{Role: auth.Cert.Role, Certificate: v.TLS.ClientCert, Key: v.TLS.ClientKey, Mount: auth.Cert.Mount}
+	}
+	return backend.TokenAuth{Token: auth.Token}
+}
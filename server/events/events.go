@@ -0,0 +1,129 @@
+// Package events subscribes to the event notifications of Vault and OpenBao, so changes to secrets reach the
+// inventory without waiting for the next crawl. Neither sends events for policies, those are only read by crawls.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"secretpaths/logging"
+	"strings"
+	"time"
+)
+
+const (
+	// minBackoff is how long to wait before subscribing again after a subscription failed.
+	minBackoff = time.Second
+	// maxBackoff limits the wait after repeated failures, e.g. while Vault is down or does not support events.
+	maxBackoff = time.Minute
+)
+
+// Kind is what an event means for the inventory.
+type Kind string
+
+const (
+	// SecretWritten is sent when a secret was created or written. A secret which is written again stays in the listing.
+	SecretWritten Kind = "secret-written"
+	// SecretDeleted is sent when a secret was deleted with all its versions and metadata, it is gone from the listing.
+	// Deleting or destroying versions keeps the secret listed and is not reported.
+	SecretDeleted Kind = "secret-deleted"
+	// Subscribed is delivered once a subscription is established, events before it may have been missed.
+	Subscribed Kind = "subscribed"
+)
+
+// Event is a change of a secret.
+type Event struct {
+	Kind Kind
+	// Type is the event type sent by Vault, e.g. kv-v2/data-write.
+	Type string
+	// Mount is the mount of the KV engine without a trailing slash, e.g. secret.
+	Mount string
+	// Path is the path of the secret in the mount, e.g. /team-a/db, the same way secretpaths lists it.
+	Path string
+	Time time.Time
+}
+
+// Source delivers events to a subscriber.
+type Source interface {
+	// Subscribe calls handle for every event until ctx is done or the subscription fails. handle is called
+	// with Subscribed first, once the subscription is established.
+	Subscribe(ctx context.Context, handle func(Event)) error
+}
+
+// Watch subscribes to the source until ctx is done. A failed subscription is retried after a delay, which doubles
+// with every failure up to a minute and starts over once a subscription was established again.
+func Watch(ctx context.Context, source Source, handle func(Event)) {
+	backoff := minBackoff
+	for {
+		err := source.Subscribe(ctx, func(event Event) {
+			if event.Kind == Subscribed {
+				backoff = minBackoff
+			}
+			handle(event)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logging.FromContext(ctx).Warn("lost the subscription to the events, subscribing again", "in", backoff, logging.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// kvKinds maps the KV v2 event types which change the listing of the secrets to their kind.
+var kvKinds = map[string]Kind{
+	"kv-v2/data-write":      SecretWritten,
+	"kv-v2/data-patch":      SecretWritten,
+	"kv-v2/metadata-write":  SecretWritten,
+	"kv-v2/metadata-patch":  SecretWritten,
+	"kv-v2/undelete":        SecretWritten,
+	"kv-v2/metadata-delete": SecretDeleted,
+}
+
+// kvOperations are the path segments following the mount in the API paths of KV v2.
+var kvOperations = []string{"data/", "metadata/", "delete/", "undelete/", "destroy/"}
+
+// message is the CloudEvents envelope Vault sends over the websocket with json=true.
+type message struct {
+	Time time.Time `json:"time"`
+	Data struct {
+		EventType string `json:"event_type"`
+		Event     struct {
+			Metadata map[string]any `json:"metadata"`
+		} `json:"event"`
+		PluginInfo struct {
+			MountPath string `json:"mount_path"`
+		} `json:"plugin_info"`
+	} `json:"data"`
+}
+
+// Parse decodes an event sent by Vault. It returns false for events which do not change the inventory,
+// e.g. the deletion of a single version of a secret.
+func Parse(data []byte) (Event, bool, error) {
+	var received message
+	if err := json.Unmarshal(data, &received); err != nil {
+		return Event{}, false, err
+	}
+	eventType := received.Data.EventType
+	event := Event{Type: eventType, Time: received.Time}
+	kind, ok := kvKinds[eventType]
+	if !ok {
+		return Event{}, false, nil
+	}
+	mount := received.Data.PluginInfo.MountPath
+	path, _ := received.Data.Event.Metadata["path"].(string)
+	path, ok = strings.CutPrefix(path, mount)
+	if !ok || mount == "" {
+		return Event{}, false, nil
+	}
+	for _, operation := range kvOperations {
+		if rest, found := strings.CutPrefix(path, operation); found {
+			event.Kind, event.Mount, event.Path = kind, strings.TrimSuffix(mount, "/"), "/"+rest
+			return event, true, nil
+		}
+	}
+	return Event{}, false, nil
+}
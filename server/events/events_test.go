@@ -0,0 +1,170 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coder/websocket"
+	"net/http"
+	"net/http/httptest"
+	"secretpaths/backend"
+	"secretpaths/events"
+	"testing"
+	"time"
+)
+
+// vaultEvent returns an event the way Vault sends it with json=true.
+func vaultEvent(eventType, path string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"id":          "a3be9fb1-b514-519f-5b25-b6f144a8c1ce",
+		"source":      "https://vaultproject.io/",
+		"specversion": "1.0",
+		"type":        "*",
+		"time":        "2024-05-01T12:00:00Z",
+		"data": map[string]any{
+			"event": map[string]any{
+				"id":       "a3be9fb1-b514-519f-5b25-b6f144a8c1ce",
+				"metadata": map[string]any{"path": path, "modified": "true"},
+			},
+			"event_type":  eventType,
+			"plugin_info": map[string]any{"mount_class": "secret", "mount_path": "secret/", "plugin": "kv"},
+		},
+	})
+	return data
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		eventType, path string
+		ok              bool
+		kind            events.Kind
+		secret          string
+	}{
+		{"kv-v2/data-write", "secret/data/team-a/db", true, events.SecretWritten, "/team-a/db"},
+		{"kv-v2/metadata-write", "secret/metadata/team-a/db", true, events.SecretWritten, "/team-a/db"},
+		{"kv-v2/undelete", "secret/undelete/team-a/db", true, events.SecretWritten, "/team-a/db"},
+		{"kv-v2/metadata-delete", "secret/metadata/team-a/db", true, events.SecretDeleted, "/team-a/db"},
+		{"kv-v2/data-delete", "secret/data/team-a/db", false, "", ""},
+		{"kv-v2/destroy", "secret/destroy/team-a/db", false, "", ""},
+		{"kv-v2/data-write", "other/data/team-a/db", false, "", ""},
+		{"kv-v2/delete", "secret/delete/team-a/db", false, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.eventType+" "+test.path, func(t *testing.T) {
+			event, ok, err := events.Parse(vaultEvent(test.eventType, test.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.ok {
+				t.Fatalf("expected: %v, got: %v", test.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if event.Kind != test.kind || event.Path != test.secret || event.Type != test.eventType {
+				t.Errorf("unexpected event %+v", event)
+			}
+			if event.Mount != "secret" {
+				t.Errorf("expected: %s, got: %s", "secret", event.Mount)
+			}
+			if event.Time.Year() != 2024 {
+				t.Errorf("expected the time of the event, got %v", event.Time)
+			}
+		})
+	}
+	if _, _, err := events.Parse([]byte("not json")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// receive returns the next event of the channel or fails after a few seconds.
+func receive(t *testing.T, received <-chan events.Event) events.Event {
+	t.Helper()
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event")
+		return events.Event{}
+	}
+}
+
+func TestWatch_Resubscribes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := events.NewFake()
+	received := make(chan events.Event, 10)
+	go events.Watch(ctx, source, func(event events.Event) {
+		received <- event
+	})
+
+	if event := receive(t, received); event.Kind != events.Subscribed {
+		t.Fatalf("expected: %s, got: %s", events.Subscribed, event.Kind)
+	}
+	source.Publish(events.Event{Kind: events.SecretWritten, Mount: "secret", Path: "/team-a/db"})
+	if event := receive(t, received); event.Kind != events.SecretWritten || event.Path != "/team-a/db" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	source.Fail(errors.New("connection reset"))
+	if event := receive(t, received); event.Kind != events.Subscribed {
+		t.Errorf("expected to subscribe again, got %+v", event)
+	}
+}
+
+func TestVault_Subscribe(t *testing.T) {
+	subscribed := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			_, _ = fmt.Fprint(w, `{"data": {"ttl": 0, "renewable": false}}`)
+		case "/v1/sys/events/subscribe/*":
+			if r.Header.Get("X-Vault-Token") != "s.events" || r.URL.Query().Get("json") != "true" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			conn, err := websocket.Accept(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.CloseNow()
+			subscribed <- r.URL.Path
+			for _, message := range [][]byte{
+				vaultEvent("kv-v2/data-delete", "secret/data/team-a/db"),
+				vaultEvent("kv-v2/data-write", "secret/data/team-a/db"),
+			} {
+				if err := conn.Write(r.Context(), websocket.MessageText, message); err != nil {
+					return
+				}
+			}
+			_ = conn.Close(websocket.StatusGoingAway, "sealed")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("delivers the events", func(t *testing.T) {
+		manager := backend.NewManagerWith(backend.Connection{Address: server.URL}, backend.TokenAuth{Token: "s.events"})
+		var received []events.Event
+		err := events.NewVault(manager).Subscribe(context.Background(), func(event events.Event) {
+			received = append(received, event)
+		})
+		if err == nil {
+			t.Error("expected the subscription to end once the connection was closed")
+		}
+		<-subscribed
+		if len(received) != 2 || received[0].Kind != events.Subscribed || received[1].Kind != events.SecretWritten {
+			t.Errorf("expected a subscription and a written secret, got %+v", received)
+		}
+	})
+	t.Run("rejected token", func(t *testing.T) {
+		manager := backend.NewManagerWith(backend.Connection{Address: server.URL}, backend.TokenAuth{Token: "s.other"})
+		err := events.NewVault(manager).Subscribe(context.Background(), func(event events.Event) {
+			t.Errorf("unexpected event %+v", event)
+		})
+		if !errors.Is(err, backend.ErrPermissionDenied) {
+			t.Errorf("expected the subscription to be denied, got %v", err)
+		}
+	})
+}
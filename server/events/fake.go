@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Fake is a source whose events are published by the caller. It stands in for Vault in tests and local setups
+// whose Vault does not send events.
+type Fake struct {
+	events chan Event
+	errors chan error
+}
+
+// NewFake returns a source which buffers up to 100 events until they are delivered.
+func NewFake() *Fake {
+	return &Fake{events: make(chan Event, 100), errors: make(chan error, 1)}
+}
+
+// Publish delivers the event to the subscriber, it blocks while the buffer is full.
+func (f *Fake) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	f.events <- event
+}
+
+// Fail ends the subscription with err, the way a lost connection to Vault does.
+func (f *Fake) Fail(err error) {
+	f.errors <- err
+}
+
+func (f *Fake) Subscribe(ctx context.Context, handle func(Event)) error {
+	handle(Event{Kind: Subscribed, Time: time.Now()})
+	for {
+		select {
+		case event := <-f.events:
+			handle(event)
+		case err := <-f.errors:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
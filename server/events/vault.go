@@ -0,0 +1,79 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coder/websocket"
+	"net/http"
+	"secretpaths/backend"
+	"secretpaths/logging"
+	"strings"
+	"time"
+)
+
+// maxMessageSize limits the size of a single event, events only carry metadata and are small.
+const maxMessageSize = 1 << 20
+
+// Vault subscribes to the events of a Vault or OpenBao server via sys/events/subscribe, which needs
+// Vault 1.16 or later. The websocket is opened with the address, TLS settings and token of the manager.
+type Vault struct {
+	manager *backend.Manager
+}
+
+// NewVault returns a source for the events of the Vault the manager is logged in to.
+func NewVault(manager *backend.Manager) *Vault {
+	return &Vault{manager: manager}
+}
+
+// Subscribe subscribes to every event type, those which do not change the inventory are dropped.
+// Vault only delivers the events of the paths the token has the subscribe capability on.
+func (v *Vault) Subscribe(ctx context.Context, handle func(Event)) error {
+	client, err := v.manager.Client(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := v.manager.Token(ctx)
+	if err != nil {
+		return err
+	}
+	configuration := client.Configuration()
+	address := strings.TrimSuffix(configuration.Address, "/") + "/v1/sys/events/subscribe/*?json=true"
+	conn, response, err := websocket.Dial(ctx, address, &websocket.DialOptions{
+		HTTPClient: configuration.HTTPClient,
+		HTTPHeader: http.Header{"X-Vault-Token": {token}},
+	})
+	if err != nil {
+		if response != nil {
+			switch response.StatusCode {
+			case http.StatusForbidden:
+				return fmt.Errorf("%w: could not subscribe to the events: %w", backend.ErrPermissionDenied, err)
+			case http.StatusNotFound, http.StatusMethodNotAllowed:
+				return fmt.Errorf("the server does not support events, it needs Vault 1.16 or later: %w", err)
+			}
+		}
+		return fmt.Errorf("%w: could not subscribe to the events: %w", backend.ErrUnreachable, err)
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(maxMessageSize)
+	logging.FromContext(ctx).Info("subscribed to the events")
+	handle(Event{Kind: Subscribed, Time: time.Now()})
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+				_ = conn.Close(websocket.StatusNormalClosure, "")
+				return ctx.Err()
+			}
+			return err
+		}
+		event, ok, err := Parse(data)
+		if err != nil {
+			logging.FromContext(ctx).Warn("could not parse an event", logging.Error(err))
+			continue
+		}
+		if ok {
+			handle(event)
+		}
+	}
+}
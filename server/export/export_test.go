@@ -0,0 +1,116 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"secretpaths/export"
+	"secretpaths/models"
+	"strings"
+	"testing"
+)
+
+func accessGraph() export.Graph {
+	tree := models.GraphEntry{AbsolutePath: "/", Id: "root", Name: "/", Folder: true, Children: []models.GraphEntry{
+		{AbsolutePath: "/team-a", Id: "team-a", Name: "team-a", Folder: true, Children: []models.GraphEntry{
+			{AbsolutePath: "/team-a/db", Id: "db", Name: "db"},
+		}},
+		{AbsolutePath: "/team-b", Id: "team-b", Name: "team-b"},
+	}}
+	reader := models.NewPolicy("reader", []models.Rule{models.NewRule("/team-a/*", []string{"read", "list"})})
+	secrets := []models.AnnotatedSecret{
+		{Path: models.Secret{Path: "/team-a/db", Mount: "secret"}, Policies: []models.Policy{reader}},
+		{Path: models.Secret{Path: "/team-b", Mount: "secret"}},
+	}
+	return export.Build(tree, secrets)
+}
+
+func TestBuild(t *testing.T) {
+	graph := accessGraph()
+	kinds := make(map[string]int)
+	for _, node := range graph.Nodes {
+		kinds[node.Kind]++
+	}
+	if kinds[export.KindPolicy] != 1 || kinds[export.KindRule] != 1 || kinds[export.KindSecret] != 2 || kinds[export.KindFolder] != 2 {
+		t.Errorf("unexpected nodes: %v", kinds)
+	}
+	var grants []export.Edge
+	for _, edge := range graph.Edges {
+		if edge.Kind == export.EdgeGrants {
+			grants = append(grants, edge)
+		}
+	}
+	if len(grants) != 1 || grants[0].To != "db" || strings.Join(grants[0].Capabilities, ",") != "read,list" {
+		t.Errorf("expected the rule to grant read,list on db, got %+v", grants)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	graph := accessGraph()
+	for format := range export.Formats {
+		t.Run(format, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := export.Write(&b, format, graph); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(b.String(), "/team-a/*") {
+				t.Errorf("expected the rule to be part of the export, got %s", b.String())
+			}
+		})
+	}
+	var b bytes.Buffer
+	if err := export.Write(&b, "svg", graph); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var b bytes.Buffer
+	if err := export.WriteGraphML(&b, accessGraph()); err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Nodes []struct{} `xml:"graph>node"`
+		Edges []struct{} `xml:"graph>edge"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Nodes) != 6 || len(document.Edges) != 5 {
+		t.Errorf("expected 6 nodes and 5 edges, got %d and %d", len(document.Nodes), len(document.Edges))
+	}
+}
+
+func TestWriteCypher_Escapes(t *testing.T) {
+	graph := export.Graph{Nodes: []export.Node{{Id: "policy:o'brien", Kind: export.KindPolicy, Label: "o'brien"}}}
+	var b bytes.Buffer
+	if err := export.WriteCypher(&b, graph); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `'o\'brien'`) {
+		t.Errorf("expected quotes to be escaped, got %s", b.String())
+	}
+}
+
+func TestWriteCypher_Labels(t *testing.T) {
+	var b bytes.Buffer
+	if err := export.WriteCypher(&b, accessGraph()); err != nil {
+		t.Fatal(err)
+	}
+	cypher := b.String()
+	if !strings.HasPrefix(cypher, "CREATE CONSTRAINT IF NOT EXISTS FOR (n:") {
+		t.Errorf("expected the ids to be constrained before the nodes are merged, got %s", cypher)
+	}
+	for _, kind := range []string{export.KindPolicy, export.KindRule, export.KindFolder, export.KindSecret} {
+		if !strings.Contains(cypher, "FOR (n:"+kind+") REQUIRE n.id IS UNIQUE;") {
+			t.Errorf("expected a constraint on the ids of %s, got %s", kind, cypher)
+		}
+	}
+	for _, line := range strings.Split(cypher, "\n") {
+		if strings.HasPrefix(line, "MATCH") && (strings.Contains(line, "(a {id:") || strings.Contains(line, "(b {id:")) {
+			t.Errorf("expected the nodes of every relationship to be matched by label, got %s", line)
+		}
+	}
+	if !strings.Contains(cypher, "MATCH (a:"+export.KindRule+" {id: ") {
+		t.Errorf("expected the rules to be matched by their label, got %s", cypher)
+	}
+}
@@ -0,0 +1,211 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	FormatDOT          = "dot"
+	FormatGraphML      = "graphml"
+	FormatCypher       = "cypher"
+	FormatNodesCSV     = "nodes.csv"
+	FormatRelationsCSV = "relationships.csv"
+)
+
+// Formats lists the supported formats together with their content type.
+var Formats = map[string]string{
+	FormatDOT:          "text/vnd.graphviz",
+	FormatGraphML:      "application/graphml+xml",
+	FormatCypher:       "text/plain; charset=utf-8",
+	FormatNodesCSV:     "text/csv",
+	FormatRelationsCSV: "text/csv",
+}
+
+// Write renders the graph in the given format.
+func Write(w io.Writer, format string, graph Graph) error {
+	switch format {
+	case FormatDOT:
+		return WriteDOT(w, graph)
+	case FormatGraphML:
+		return WriteGraphML(w, graph)
+	case FormatCypher:
+		return WriteCypher(w, graph)
+	case FormatNodesCSV:
+		return WriteNodesCSV(w, graph)
+	case FormatRelationsCSV:
+		return WriteRelationshipsCSV(w, graph)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+var dotShapes = map[string]string{
+	KindPolicy: "box",
+	KindRule:   "note",
+	KindFolder: "folder",
+	KindSecret: "ellipse",
+}
+
+func WriteDOT(w io.Writer, graph Graph) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	var b strings.Builder
+	b.WriteString("digraph secretpaths {\n\trankdir=LR;\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&b, "\t\"%s\" [label=\"%s\", shape=%s];\n", quote.Replace(node.Id), quote.Replace(node.Label), dotShapes[node.Kind])
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&b, "\t\"%s\" -> \"%s\"", quote.Replace(edge.From), quote.Replace(edge.To))
+		if len(edge.Capabilities) > 0 {
+			fmt.Fprintf(&b, " [label=\"%s\"]", quote.Replace(joinCapabilities(edge.Capabilities)))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name        `xml:"graphml"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Keys    []graphMLKey    `xml:"key"`
+	Graph   graphMLDocument `xml:"graph"`
+}
+
+type graphMLKey struct {
+	Id   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLDocument struct {
+	Id          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func WriteGraphML(w io.Writer, graph Graph) error {
+	document := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{Id: "kind", For: "node", Name: "kind", Type: "string"},
+			{Id: "label", For: "node", Name: "label", Type: "string"},
+			{Id: "path", For: "node", Name: "path", Type: "string"},
+			{Id: "type", For: "edge", Name: "type", Type: "string"},
+			{Id: "capabilities", For: "edge", Name: "capabilities", Type: "string"},
+		},
+		Graph: graphMLDocument{Id: "secretpaths", EdgeDefault: "directed"},
+	}
+	for _, node := range graph.Nodes {
+		data := []graphMLData{{Key: "kind", Value: node.Kind}, {Key: "label", Value: node.Label}}
+		if node.Path != "" {
+			data = append(data, graphMLData{Key: "path", Value: node.Path})
+		}
+		document.Graph.Nodes = append(document.Graph.Nodes, graphMLNode{Id: node.Id, Data: data})
+	}
+	for _, edge := range graph.Edges {
+		data := []graphMLData{{Key: "type", Value: edge.Kind}}
+		if len(edge.Capabilities) > 0 {
+			data = append(data, graphMLData{Key: "capabilities", Value: joinCapabilities(edge.Capabilities)})
+		}
+		document.Graph.Edges = append(document.Graph.Edges, graphMLEdge{Source: edge.From, Target: edge.To, Data: data})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteCypher writes MERGE statements, so the export can be loaded into Neo4j repeatedly. The ids are unique
+// per label, which lets the relationships look up their nodes through an index instead of scanning every node.
+func WriteCypher(w io.Writer, graph Graph) error {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	var b strings.Builder
+	kinds := make(map[string]string, len(graph.Nodes))
+	constrained := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		kinds[node.Id] = node.Kind
+		if !constrained[node.Kind] {
+			constrained[node.Kind] = true
+			fmt.Fprintf(&b, "CREATE CONSTRAINT IF NOT EXISTS FOR (n:%s) REQUIRE n.id IS UNIQUE;\n", node.Kind)
+		}
+	}
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&b, "MERGE (n:%s {id: '%s'}) SET n.name = '%s'", node.Kind, quote.Replace(node.Id), quote.Replace(node.Label))
+		if node.Path != "" {
+			fmt.Fprintf(&b, ", n.path = '%s'", quote.Replace(node.Path))
+		}
+		b.WriteString(";\n")
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&b, "MATCH (a%s {id: '%s'}), (b%s {id: '%s'}) MERGE (a)-[r:%s]->(b)",
+			label(kinds[edge.From]), quote.Replace(edge.From), label(kinds[edge.To]), quote.Replace(edge.To), edge.Kind)
+		if len(edge.Capabilities) > 0 {
+			quoted := make([]string, 0, len(edge.Capabilities))
+			for _, capability := range edge.Capabilities {
+				quoted = append(quoted, "'"+quote.Replace(capability)+"'")
+			}
+			fmt.Fprintf(&b, " SET r.capabilities = [%s]", strings.Join(quoted, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// label returns the label of a node of the kind in a pattern, nodes which are not part of the export have none.
+func label(kind string) string {
+	if kind == "" {
+		return ""
+	}
+	return ":" + kind
+}
+
+// WriteNodesCSV writes the nodes in the header format of neo4j-admin database import.
+func WriteNodesCSV(w io.Writer, graph Graph) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"id:ID", ":LABEL", "name", "path"})
+	for _, node := range graph.Nodes {
+		_ = writer.Write([]string{node.Id, node.Kind, node.Label, node.Path})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteRelationshipsCSV writes the edges in the header format of neo4j-admin database import.
+func WriteRelationshipsCSV(w io.Writer, graph Graph) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{":START_ID", ":END_ID", ":TYPE", "capabilities:string[]"})
+	for _, edge := range graph.Edges {
+		_ = writer.Write([]string{edge.From, edge.To, edge.Kind, strings.Join(edge.Capabilities, ";")})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"secretpaths/models"
+	"sort"
+	"strings"
+)
+
+const (
+	KindPolicy = "Policy"
+	KindRule   = "Rule"
+	KindFolder = "Folder"
+	KindSecret = "Secret"
+
+	EdgeHasRule  = "HAS_RULE"
+	EdgeGrants   = "GRANTS"
+	EdgeContains = "CONTAINS"
+)
+
+type Node struct {
+	Id    string
+	Kind  string
+	Label string
+	Path  string
+}
+
+type Edge struct {
+	From         string
+	To           string
+	Kind         string
+	Capabilities []string
+}
+
+// Graph is the access graph of policies, their rules and the secrets the rules grant access to.
+// Folders and secrets are connected the same way as in the tree of secrets.
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// Build creates the access graph from the tree of secrets and the policies with access to them.
+func Build(tree models.GraphEntry, secrets []models.AnnotatedSecret) Graph {
+	var graph Graph
+	graph.addTree(tree)
+
+	ids := make(map[string]string)
+	collectSecretIds(tree, ids)
+
+	policies := make(map[string]models.Policy)
+	rules := make(map[string]struct{})
+	for _, secret := range secrets {
+		secretId, ok := ids[secret.Path.Path]
+		if !ok {
+			continue
+		}
+		for _, policy := range secret.Policies {
+			rule, ok := policy.MatchingRule(secret.Path.Path)
+			if !ok {
+				continue
+			}
+			policies[policy.Name] = policy
+			id := ruleId(policy.Name, rule.Path)
+			if _, ok := rules[id]; !ok {
+				rules[id] = struct{}{}
+				graph.Nodes = append(graph.Nodes, Node{Id: id, Kind: KindRule, Label: rule.Path, Path: rule.Path})
+				graph.Edges = append(graph.Edges, Edge{From: policyId(policy.Name), To: id, Kind: EdgeHasRule})
+			}
+			graph.Edges = append(graph.Edges, Edge{From: id, To: secretId, Kind: EdgeGrants, Capabilities: rule.Capabilities})
+		}
+	}
+
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		graph.Nodes = append(graph.Nodes, Node{Id: policyId(name), Kind: KindPolicy, Label: name})
+	}
+	return graph
+}
+
+func (g *Graph) addTree(entry models.GraphEntry) {
+	kind := KindSecret
+	if entry.Folder {
+		kind = KindFolder
+	}
+	g.Nodes = append(g.Nodes, Node{Id: entry.Id, Kind: kind, Label: entry.Name, Path: entry.AbsolutePath})
+	for _, child := range entry.Children {
+		g.Edges = append(g.Edges, Edge{From: entry.Id, To: child.Id, Kind: EdgeContains})
+		g.addTree(child)
+	}
+}
+
+func collectSecretIds(entry models.GraphEntry, ids map[string]string) {
+	if !entry.Folder {
+		ids[entry.AbsolutePath] = entry.Id
+	}
+	for _, child := range entry.Children {
+		collectSecretIds(child, ids)
+	}
+}
+
+func policyId(name string) string {
+	return "policy:" + name
+}
+
+func ruleId(policy, path string) string {
+	return "rule:" + policy + ":" + path
+}
+
+func joinCapabilities(capabilities []string) string {
+	return strings.Join(capabilities, ",")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"os"
+	"os/signal"
+	"secretpaths/config"
+	"secretpaths/export"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+func exportGraph(c *gin.Context) {
+	format := c.Param("format")
+	contentType, ok := export.Formats[format]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown export format %q", format)})
+		return
+	}
+	target, ok := selectCluster(c)
+	if !ok {
+		return
+	}
+	graph, err := buildVisibleExport(c, target)
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename=secretpaths."+format)
+	c.Status(http.StatusOK)
+	if err := export.Write(c.Writer, format, graph); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// runExport crawls Vault once and writes the access graph, e.g. `secretpaths export -format dot`.
+func runExport(args []string) error {
+	formats := make([]string, 0, len(export.Formats))
+	for format := range export.Formats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", export.FormatDOT, "one of "+strings.Join(formats, ", "))
+	output := flags.String("output", "-", "file to write to, - writes to stdout")
+	name := flags.String("cluster", "", "cluster to export, the first one if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, ok := export.Formats[*format]; !ok {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	settings, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	var target *cluster
+	for _, candidate := range newClusters(settings) {
+		if target == nil && (*name == "" || candidate.name == *name) {
+			target = candidate
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("unknown cluster %q", *name)
+	}
+	defer target.manager.Close(context.Background())
+	// an interrupt stops the crawl, the token is still revoked
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	graph, err := buildExport(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+	if err := export.Write(writer, *format, graph); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func buildExport(ctx context.Context, target *cluster) (export.Graph, error) {
+	tree, err := cachedGraph(ctx, target)
+	if err != nil {
+		return export.Graph{}, err
+	}
+	secrets, err := cachedAnnotatedSecrets(ctx, target)
+	if err != nil {
+		return export.Graph{}, err
+	}
+	return export.Build(tree, secrets), nil
+}
+
+// buildVisibleExport builds the access graph restricted to what the caller of the request may see.
+func buildVisibleExport(c *gin.Context, target *cluster) (export.Graph, error) {
+	if !userViews(c) {
+		return buildExport(c, target)
+	}
+	tree, err := visibleGraph(c, target)
+	if err != nil {
+		return export.Graph{}, err
+	}
+	secrets, err := visibleSecrets(c, target)
+	if err != nil {
+		return export.Graph{}, err
+	}
+	return export.Build(tree, secrets), nil
+}
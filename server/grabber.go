@@ -3,22 +3,31 @@ package main
 import (
 	"context"
 	"errors"
-	"github.com/google/uuid"
 	"github.com/hashicorp/vault-client-go"
-	"log"
-	"os"
+	"go.opentelemetry.io/otel/attribute"
+	"secretpaths/logging"
 	"secretpaths/models"
+	"secretpaths/tracing"
 	"strings"
+	"time"
 )
 
+// GetPolicies returns the ACL policies of Vault, policies which cannot be read or parsed are skipped.
 func GetPolicies(ctx context.Context, client *vault.Client) ([]models.Policy, error) {
+	policies, _, err := listPolicies(ctx, client)
+	return policies, err
+}
+
+// listPolicies returns the ACL policies of Vault and the names of those which could not be read or parsed.
+func listPolicies(ctx context.Context, client *vault.Client) ([]models.Policy, []string, error) {
 	response, err := client.System.PoliciesListAclPolicies(ctx)
 
 	if err != nil {
-		log.Default().Println("error listing policies")
-		log.Println(err)
+		logging.FromContext(ctx).Error("could not list the policies", logging.Error(err))
+		return nil, nil, err
 	}
 	var policies = make([]models.Policy, 0)
+	var unparsed []string
 	for _, rawPolicy := range response.Data.Keys {
 		if rawPolicy == "root" {
 			// skip the root policy, as it is not a real policy
@@ -26,44 +35,56 @@ func GetPolicies(ctx context.Context, client *vault.Client) ([]models.Policy, er
 		}
 		policy, err := parsePolicy(ctx, client, rawPolicy)
 		if err != nil {
-			log.Println("could not parse policy", rawPolicy)
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			logging.FromContext(ctx).Warn("could not parse policy", "policy", rawPolicy, logging.Error(err))
+			unparsed = append(unparsed, rawPolicy)
 		} else {
 			policies = append(policies, policy)
 		}
 	}
-	return policies, nil
+	return policies, unparsed, nil
 }
 
 func parsePolicy(ctx context.Context, client *vault.Client, name string) (policy models.Policy, err error) {
 	p, err := client.System.PoliciesReadAclPolicy(ctx, name)
 	if err != nil {
-		log.Default().Println("error reading policy", name)
-		log.Println(err)
+		logging.FromContext(ctx).Warn("could not read policy", "policy", name, logging.Error(err))
+		return
 	}
 	policy, err = models.FromHCL(name, []byte(p.Data.Policy))
 	return
 }
 
-func recursivelyGetPaths(ctx context.Context, client *vault.Client, path, kvEngine string) ([]models.Secret, error) {
+func recursivelyGetPaths(ctx context.Context, client *vault.Client, path, kvEngine string) (secrets []models.Secret, err error) {
+	// every folder is a span, so slow folders stand out in the trace of a crawl
+	ctx, span := tracing.Start(ctx, "list folder", tracing.Folder(kvEngine, path)...)
+	defer func() {
+		span.SetAttributes(attribute.Int("secretpaths.secrets", len(secrets)))
+		tracing.End(span, err)
+	}()
 	response, err := client.Secrets.KvV2List(ctx, path, vault.WithMountPath(kvEngine))
 	if err != nil {
 		var responseError *vault.ResponseError
-		errors.As(err, &responseError)
-		if responseError.StatusCode == 404 {
-			log.Default().Println("there is nothing at", path)
+		if errors.As(err, &responseError) && responseError.StatusCode == 404 {
+			logging.FromContext(ctx).Debug("there is nothing in the folder", "mount", kvEngine, logging.Path(path))
 			return nil, nil
 		}
 		return nil, err
 	}
-	var secrets []models.Secret
 	for _, subPath := range response.Data.Keys {
 		if !strings.HasSuffix(subPath, "/") {
-			secrets = append(secrets, models.Secret{Path: path + subPath})
+			secrets = append(secrets, models.Secret{Path: path + subPath, Mount: kvEngine})
 			continue
 		}
 		subSecrets, err := recursivelyGetPaths(ctx, client, path+subPath, kvEngine)
 		if err != nil {
-			log.Default().Println("error listing paths of ", path+subPath)
+			if ctx.Err() != nil {
+				// the crawl was cancelled, the other folders would fail as well
+				return nil, ctx.Err()
+			}
+			logging.FromContext(ctx).Warn("could not list the folder", "mount", kvEngine, logging.Path(path+subPath), logging.Error(err))
 			continue
 		}
 		secrets = append(secrets, subSecrets...)
@@ -71,70 +92,30 @@ func recursivelyGetPaths(ctx context.Context, client *vault.Client, path, kvEngi
 	return secrets, err
 }
 
-func recursivelyGetGraphPaths(ctx context.Context, client *vault.Client, path, kvEngine string, stopAtRecursion int) ([]models.GraphEntry, error) {
-	response, err := client.Secrets.KvV2List(ctx, path, vault.WithMountPath(kvEngine))
-	if stopAtRecursion == 0 {
-		return []models.GraphEntry{}, nil
-	}
-	if stopAtRecursion > 0 {
-		stopAtRecursion--
-	}
-	if err != nil {
-		var responseError *vault.ResponseError
-		errors.As(err, &responseError)
-		if responseError.StatusCode == 404 {
-			log.Default().Println("there is nothing at", path)
-			return nil, nil
-		}
-		return nil, err
-	}
-	var secrets []models.GraphEntry
-	for _, subPath := range response.Data.Keys {
-		id := uuid.New().String()
-		if !strings.HasSuffix(subPath, "/") {
-			secrets = append(secrets, models.GraphEntry{AbsolutePath: path + subPath, Id: id, Name: subPath})
-			continue
-		}
-		subSecrets, err := recursivelyGetGraphPaths(ctx, client, path+subPath, kvEngine, stopAtRecursion)
-		if err != nil {
-			log.Default().Println("error listing paths of ", path+subPath)
-			continue
-		}
-		subPath = strings.Replace(subPath, "/", "", 1)
-		secrets = append(secrets, models.GraphEntry{AbsolutePath: path + subPath, Id: id, Name: subPath, Children: subSecrets})
-	}
-	return secrets, err
-
-}
-
-func GetPaths(ctx context.Context, client *vault.Client) ([]models.Secret, error) {
-	kvEngine := "secret"
-
-	val, ok := os.LookupEnv("VAULT_KV_ENGINE")
-	if ok {
-		kvEngine = val
-	}
-
+func GetPaths(ctx context.Context, client *vault.Client, kvEngine string) ([]models.Secret, error) {
 	secrets, err := recursivelyGetPaths(ctx, client, "/", kvEngine)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(ctx).Error("could not list the secrets", "mount", kvEngine, logging.Error(err))
 	}
 
 	return secrets, err
 }
 
-func getGraphPaths(ctx context.Context, client *vault.Client, stopAtRecursion int) (models.GraphEntry, error) {
-	kvEngine := "secret"
-
-	val, ok := os.LookupEnv("VAULT_KV_ENGINE")
-	if ok {
-		kvEngine = val
-	}
-
-	secrets, err := recursivelyGetGraphPaths(ctx, client, "/", kvEngine, stopAtRecursion)
-	if err != nil {
-		log.Println(err)
+// GetUpdatedTimes reads the time every secret was last written from its KV metadata.
+// This needs read access to the metadata, so callers only do it if read_metadata is configured.
+// Secrets whose metadata cannot be read are skipped, the error is only returned if the context is done.
+func GetUpdatedTimes(ctx context.Context, client *vault.Client, secrets []models.Secret) (map[string]time.Time, error) {
+	updated := make(map[string]time.Time, len(secrets))
+	for _, secret := range secrets {
+		response, err := client.Secrets.KvV2ReadMetadata(ctx, secret.Path, vault.WithMountPath(secret.Mount))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logging.FromContext(ctx).Warn("could not read the metadata", "mount", secret.Mount, logging.Path(secret.Path), logging.Error(err))
+			continue
+		}
+		updated[secret.Path] = response.Data.UpdatedTime
 	}
-
-	return models.GraphEntry{AbsolutePath: "/", Id: "/", Name: "/", Children: secrets}, err
+	return updated, nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"secretpaths/apiauth"
+	"secretpaths/config"
+	"secretpaths/logging"
+	"slices"
+	"time"
+)
+
+// guard authenticates the callers of the API and checks that their role allows the endpoint.
+type guard struct {
+	authenticator apiauth.Authenticator
+	anonymous     apiauth.Role
+}
+
+// newGuard checks the credentials configured in settings, Vault tokens are looked up in the first cluster.
+func newGuard(settings config.API, clusters []*cluster) guard {
+	lookup := func(ctx context.Context, token string) (string, []string, error) {
+		identity, err := lookupToken(ctx, clusters[0], token)
+		if errors.Is(err, errUnauthenticated) {
+			return "", nil, fmt.Errorf("%w: %w", apiauth.ErrInvalidCredentials, err)
+		}
+		return identity.DisplayName, identity.Policies, err
+	}
+	if !settings.Secured() {
+		slog.Warn("no credentials are configured for the API", "role", settings.Anonymous())
+	}
+	return guard{authenticator: settings.Authenticator(lookup), anonymous: settings.Anonymous()}
+}
+
+// require rejects callers whose role is below role, with 401 if they did not authenticate and 403 otherwise.
+// If their credentials cannot be checked, e.g. because the identity provider is down, it answers 503.
+func (g guard) require(role apiauth.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, authenticated, err := g.authenticator.Authenticate(c.Request)
+		if errors.Is(err, apiauth.ErrInvalidCredentials) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, apiauth.ErrUnavailable) {
+			logging.FromContext(c.Request.Context()).Error("could not authenticate the caller", logging.Error(err))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			vaultError(c, err)
+			c.Abort()
+			return
+		}
+		if !authenticated {
+			principal = apiauth.Principal{Name: "anonymous", Role: g.anonymous}
+		}
+		if principal.Role < role {
+			status := http.StatusForbidden
+			if !authenticated {
+				status = http.StatusUnauthorized
+				c.Header("WWW-Authenticate", `Bearer realm="secretpaths"`)
+			}
+			c.AbortWithStatusJSON(status, gin.H{"error": fmt.Sprintf("the %s role is required", role)})
+			return
+		}
+		c.Set("principal", principal)
+		c.Next()
+	}
+}
+
+// corsMiddleware allows browsers on the origins to call the API. Without origins, browsers only allow calls
+// from the origin of the API itself. Credentials are only allowed for listed origins, never for *.
+func corsMiddleware(origins []string) gin.HandlerFunc {
+	if len(origins) == 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	settings := cors.Config{
+		AllowMethods:  []string{http.MethodGet, http.MethodPost, http.MethodOptions},
+		AllowHeaders:  []string{"Origin", "Authorization", "Content-Type", apiauth.APIKeyHeader, apiauth.VaultTokenHeader},
+		ExposeHeaders: []string{"Content-Length", "X-Total-Count", "X-Next-Cursor", "X-Unavailable-Clusters"},
+		MaxAge:        12 * time.Hour,
+	}
+	if slices.Contains(origins, "*") {
+		settings.AllowAllOrigins = true
+	} else {
+		settings.AllowOrigins = origins
+		settings.AllowCredentials = true
+	}
+	return cors.New(settings)
+}
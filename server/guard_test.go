@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"secretpaths/apiauth"
+	"secretpaths/config"
+	"testing"
+	"time"
+)
+
+// identityProvider serves the discovery document and the key of an OpenID Connect provider,
+// and signs tokens for the groups.
+func identityProvider(t *testing.T) (string, func(groups ...string) string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "signing",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	sign := func(groups ...string) string {
+		encode := func(value any) string {
+			raw, _ := json.Marshal(value)
+			return base64.RawURLEncoding.EncodeToString(raw)
+		}
+		signed := encode(map[string]string{"alg": "RS256", "kid": "signing", "typ": "JWT"}) + "." + encode(map[string]any{
+			"iss":    server.URL,
+			"aud":    "secretpaths",
+			"sub":    "1234",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"groups": groups,
+		})
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	return server.URL, sign
+}
+
+func TestGuard_Roles(t *testing.T) {
+	vault := newFakeVault(t)
+	target := newTestCluster("primary", vault)
+	crawl(t, target)
+	issuer, sign := identityProvider(t)
+	server := newTestServer(t, []*cluster{target}, func(settings *config.Config) {
+		settings.API.OIDC = config.APIOIDC{
+			Issuer:   issuer,
+			Audience: "secretpaths",
+			Roles:    map[string]string{"developers": "viewer", "security": "auditor"},
+		}
+		settings.API.APIKeys = []config.APIKey{
+			{Name: "dashboard", Key: "viewer-key-0123456789", Role: "viewer"},
+			{Name: "audit", Key: "auditor-key-0123456789", Role: "auditor"},
+		}
+		settings.API.VaultRoles = map[string]string{"team-a": "viewer", "team-b": "auditor"}
+	})
+	viewerRoutes := []string{"/v1/paths", "/v1/graph", "/v1/graph/children"}
+	auditorRoutes := []string{"/v1/policies", "/v1/annotatedSecrets", "/v1/annotated?path=/team-a/db", "/v1/export/dot"}
+
+	callers := map[string]struct {
+		headers  []string
+		auditor  bool
+		rejected int
+	}{
+		"viewer key":          {[]string{apiauth.APIKeyHeader, "viewer-key-0123456789"}, false, http.StatusForbidden},
+		"viewer vault token":  {[]string{apiauth.VaultTokenHeader, "s.alice"}, false, http.StatusForbidden},
+		"auditor key":         {[]string{apiauth.APIKeyHeader, "auditor-key-0123456789"}, true, 0},
+		"auditor vault token": {[]string{apiauth.VaultTokenHeader, "s.bob"}, true, 0},
+		"viewer oidc token":   {[]string{"Authorization", "Bearer " + sign("developers")}, false, http.StatusForbidden},
+		"auditor oidc token":  {[]string{"Authorization", "Bearer " + sign("developers", "security")}, true, 0},
+		"anonymous":           {nil, false, http.StatusUnauthorized},
+	}
+	for name, caller := range callers {
+		t.Run(name, func(t *testing.T) {
+			for _, route := range viewerRoutes {
+				expected := http.StatusOK
+				if caller.headers == nil {
+					expected = http.StatusUnauthorized
+				}
+				if response := call(t, http.MethodGet, server.URL+route, nil, caller.headers...); response.StatusCode != expected {
+					t.Errorf("%s: expected: %d, got: %d", route, expected, response.StatusCode)
+				}
+			}
+			for _, route := range auditorRoutes {
+				expected := caller.rejected
+				if caller.auditor {
+					expected = http.StatusOK
+				}
+				if response := call(t, http.MethodGet, server.URL+route, nil, caller.headers...); response.StatusCode != expected {
+					t.Errorf("%s: expected: %d, got: %d", route, expected, response.StatusCode)
+				}
+			}
+			if response := call(t, http.MethodPost, server.URL+"/v1/refresh", nil, caller.headers...); response.StatusCode == http.StatusAccepted {
+				t.Error("expected only admins to start a crawl")
+			}
+		})
+	}
+
+	t.Run("invalid key", func(t *testing.T) {
+		response := call(t, http.MethodGet, server.URL+"/v1/paths", nil, apiauth.APIKeyHeader, "guessed-key-0123456789")
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected: %d, got: %d", http.StatusUnauthorized, response.StatusCode)
+		}
+	})
+}
+
+func TestGuard_ProviderDown(t *testing.T) {
+	target := newTestCluster("primary", newFakeVault(t))
+	crawl(t, target)
+	issuer, sign := identityProvider(t)
+	token := sign("developers")
+	server := newTestServer(t, []*cluster{target}, func(settings *config.Config) {
+		settings.API.OIDC = config.APIOIDC{Issuer: issuer + "/down", Audience: "secretpaths"}
+	})
+
+	response := call(t, http.MethodGet, server.URL+"/v1/paths", nil, "Authorization", "Bearer "+token)
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected: %d, got: %d", http.StatusServiceUnavailable, response.StatusCode)
+	}
+}
@@ -0,0 +1,166 @@
+// Package logging sets up the structured logs of secretpaths and hides the paths of secrets in them if configured.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// RequestIdHeader carries the id of a request, which is taken from the caller if it sends one.
+const RequestIdHeader = "X-Request-Id"
+
+// The ways to log the paths of secrets.
+const (
+	PathsPlain  = "plain"
+	PathsHash   = "hash"
+	PathsRedact = "redact"
+)
+
+// paths is how paths are logged, it is set once by Setup before anything is logged.
+var paths = PathsPlain
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey struct{}
+
+// Setup makes a logger writing to w the default of slog and of the log package.
+// The format is text or json, the level debug, info, warn or error and pathMode one of the Paths constants.
+func Setup(w io.Writer, format, level, pathMode string) error {
+	var minimum slog.Level
+	if err := minimum.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	options := &slog.HandlerOptions{Level: minimum}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	switch pathMode {
+	case "":
+		paths = PathsPlain
+	case PathsPlain, PathsHash, PathsRedact:
+		paths = pathMode
+	default:
+		return fmt.Errorf("unknown path mode %q", pathMode)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// Redact returns the path as it may be logged: unchanged, a short hash which stays the same across
+// log lines, or a placeholder.
+func Redact(path string) string {
+	switch paths {
+	case PathsHash:
+		sum := sha256.Sum256([]byte(path))
+		return "sha256:" + hex.EncodeToString(sum[:6])
+	case PathsRedact:
+		return "[redacted]"
+	default:
+		return path
+	}
+}
+
+// Path is the attribute of the path of a secret or folder.
+func Path(path string) slog.Attr {
+	return slog.String("path", Redact(path))
+}
+
+// Error is the attribute of an error. Errors of the HTTP client contain the URL of the request,
+// which ends in the path of the secret, so its path is redacted like the paths of secrets.
+func Error(err error) slog.Attr {
+	if err == nil {
+		return slog.Attr{}
+	}
+	message := err.Error()
+	var requestError *url.Error
+	if paths != PathsPlain && errors.As(err, &requestError) {
+		message = strings.ReplaceAll(message, requestError.URL, RedactURL(requestError.URL))
+	}
+	return slog.String("error", message)
+}
+
+// RedactURL returns the URL of a request to Vault as it may be logged. Unless paths are logged as they are,
+// its path is redacted like the paths of secrets and its query, which may contain paths as well, is left out.
+func RedactURL(raw string) string {
+	if paths == PathsPlain {
+		return raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Redact(raw)
+	}
+	redacted := *parsed
+	redacted.Path, redacted.RawPath, redacted.RawQuery = Redact(parsed.Path), "", ""
+	return redacted.String()
+}
+
+// With returns a context whose logger adds the attributes to every line, e.g. the cluster of a crawl.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).With(args...))
+}
+
+// FromContext returns the logger of the context, or the default one.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Middleware gives every request an id, which is sent back in RequestIdHeader and added to every line logged
+// while handling it, and logs the request once it is handled. Probes and scrapes are not logged.
+// The query is left out, it contains the paths of secrets.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Header(RequestIdHeader, id)
+		c.Request = c.Request.WithContext(With(c.Request.Context(), "request_id", id))
+		start := time.Now()
+		c.Next()
+		switch c.Request.URL.Path {
+		case "/v1/healthz", "/v1/livez", "/v1/readyz", "/metrics":
+			return
+		}
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("client", c.ClientIP()),
+		)
+	}
+}
+
+// Recovery responds with 500 if a handler panics and logs the panic with its stack.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		FromContext(c.Request.Context()).Error("panic", "error", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
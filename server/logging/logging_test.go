@@ -0,0 +1,164 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secretpaths/logging"
+	"strings"
+	"testing"
+)
+
+func setup(t *testing.T, level, paths string) *bytes.Buffer {
+	previous := slog.Default()
+	t.Cleanup(func() {
+		_ = logging.Setup(&bytes.Buffer{}, "text", "info", logging.PathsPlain)
+		slog.SetDefault(previous)
+	})
+	var output bytes.Buffer
+	if err := logging.Setup(&output, "json", level, paths); err != nil {
+		t.Fatal(err)
+	}
+	return &output
+}
+
+func lines(t *testing.T, output *bytes.Buffer) []map[string]any {
+	var result []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected json, got %q: %v", line, err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestSetup(t *testing.T) {
+	if err := logging.Setup(&bytes.Buffer{}, "xml", "info", logging.PathsPlain); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+	if err := logging.Setup(&bytes.Buffer{}, "text", "verbose", logging.PathsPlain); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+	if err := logging.Setup(&bytes.Buffer{}, "text", "info", "encrypt"); err == nil {
+		t.Error("expected an unknown path mode to be rejected")
+	}
+
+	output := setup(t, "warn", logging.PathsPlain)
+	slog.Info("dropped")
+	slog.Warn("kept")
+	entries := lines(t, output)
+	if len(entries) != 1 || entries[0]["msg"] != "kept" {
+		t.Errorf("expected only the warning to be logged, got %v", entries)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	setup(t, "info", logging.PathsPlain)
+	if got := logging.Redact("/team-a/db"); got != "/team-a/db" {
+		t.Errorf("expected the plain path, got %q", got)
+	}
+
+	setup(t, "info", logging.PathsHash)
+	hashed := logging.Redact("/team-a/db")
+	if !strings.HasPrefix(hashed, "sha256:") || strings.Contains(hashed, "team-a") {
+		t.Errorf("expected a hash, got %q", hashed)
+	}
+	if logging.Redact("/team-a/db") != hashed || logging.Redact("/team-b/db") == hashed {
+		t.Error("expected the hash to be stable and to differ between paths")
+	}
+
+	setup(t, "info", logging.PathsRedact)
+	if got := logging.Redact("/team-a/db"); got != "[redacted]" {
+		t.Errorf("expected the path to be redacted, got %q", got)
+	}
+}
+
+func TestError(t *testing.T) {
+	output := setup(t, "info", logging.PathsRedact)
+	err := fmt.Errorf("could not read: %w", &url.Error{
+		Op:  "Get",
+		URL: "https://vault.example.com/v1/secret/metadata/team-a/db?version=2",
+		Err: fmt.Errorf("connection refused"),
+	})
+	slog.Error("failed", logging.Error(err))
+	message := lines(t, output)[0]["error"].(string)
+	if strings.Contains(message, "team-a") || !strings.Contains(message, "vault.example.com") {
+		t.Errorf("expected the path of the URL to be redacted, got %q", message)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	output := setup(t, "info", logging.PathsPlain)
+	router := gin.New()
+	router.Use(logging.Middleware(), logging.Recovery())
+	router.GET("/v1/secrets", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("handling")
+		c.Status(http.StatusOK)
+	})
+	router.GET("/v1/panic", func(c *gin.Context) {
+		panic("broken")
+	})
+	router.GET("/v1/healthz", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/secrets?query=team-a", nil)
+	request.Header.Set(logging.RequestIdHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Header().Get(logging.RequestIdHeader) != "abc-123" {
+		t.Errorf("expected the id of the caller to be sent back, got %q", recorder.Header().Get(logging.RequestIdHeader))
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/v1/panic", nil)
+	request.Header.Set(logging.RequestIdHeader, "not a valid id!")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected: %d, got: %d", http.StatusInternalServerError, recorder.Code)
+	}
+	generated := recorder.Header().Get(logging.RequestIdHeader)
+	if generated == "" || generated == "not a valid id!" {
+		t.Errorf("expected a generated id, got %q", generated)
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/healthz", nil))
+
+	entries := lines(t, output)
+	if len(entries) != 4 {
+		t.Fatalf("expected two lines of each request and none of the probe, got %v", entries)
+	}
+	for i, expected := range []string{"abc-123", "abc-123", generated, generated} {
+		if entries[i]["request_id"] != expected {
+			t.Errorf("expected line %d to have the request id %s, got %v", i, expected, entries[i])
+		}
+	}
+	if entries[1]["route"] != "/v1/secrets" || strings.Contains(output.String(), "query=team-a") {
+		t.Errorf("expected the route to be logged without the query, got %v", entries[1])
+	}
+	if entries[2]["msg"] != "panic" || entries[3]["level"] != "ERROR" || entries[3]["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("expected the panic and the failed request to be logged, got %v", entries[2:])
+	}
+}
+
+func TestWith(t *testing.T) {
+	output := setup(t, "info", logging.PathsPlain)
+	ctx := logging.With(context.Background(), "cluster", "primary")
+	logging.FromContext(logging.With(ctx, "refresh", "42")).Info("crawling")
+	entry := lines(t, output)[0]
+	if entry["cluster"] != "primary" || entry["refresh"] != "42" {
+		t.Errorf("expected the attributes of the context, got %v", entry)
+	}
+}
@@ -2,172 +2,293 @@ package main
 
 import (
 	"context"
-	"github.com/gin-contrib/cors"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/hashicorp/vault-client-go"
 	"github.com/maypok86/otter"
+	"go.opentelemetry.io/otel/attribute"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"secretpaths/apiauth"
 	"secretpaths/backend"
+	"secretpaths/config"
+	"secretpaths/logging"
+	"secretpaths/metrics"
 	"secretpaths/models"
+	"secretpaths/tracing"
+	"slices"
 	"strconv"
+	"syscall"
 	"time"
 )
 
+// maxGraphDepth limits how many levels /v1/graph/children returns at once.
+const maxGraphDepth = 10
+
+// statusClientClosedRequest is logged for requests whose caller went away before they were answered.
+const statusClientClosedRequest = 499
+
 func getPolicies(c *gin.Context) {
-	cache := c.MustGet("cache").(otter.Cache[string, any])
-	if cache.Has("policies") {
-		var policies, _ = cache.Get("policies")
-		c.IndentedJSON(http.StatusOK, policies)
+	target, ok := selectCluster(c)
+	if !ok {
+		return
+	}
+	cache, manager := target.cache, target.manager
+	var policies []models.Policy
+	if cached, ok := cache.Get("policies"); ok {
+		policies = cached.([]models.Policy)
 	} else {
-		ctx := context.Background()
-		client, err := backend.AutoAuth(ctx)
+		client, err := manager.Client(c)
 		if err != nil {
-			log.Printf("error: %v", err)
+			vaultError(c, err)
+			return
 		}
-		policies, err := GetPolicies(ctx, client)
+		policies, err = GetPolicies(c, client)
 		if err != nil {
-			log.Println(err)
+			vaultError(c, err)
+			return
 		}
 		cache.Set("policies", policies)
-		c.IndentedJSON(http.StatusOK, policies)
 	}
+	if userViews(c) {
+		identity, err := lookupCaller(c, target)
+		if err != nil {
+			vaultError(c, err)
+			return
+		}
+		var own []models.Policy
+		for _, policy := range policies {
+			if identity.hasAny([]string{policy.Name}) {
+				own = append(own, policy)
+			}
+		}
+		policies = own
+	}
+	c.IndentedJSON(http.StatusOK, policies)
 }
 
 func healthz(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
-	})
+	response := gin.H{"status": "ok"}
+	clusters := gin.H{}
+	for _, target := range c.MustGet("clusters").([]*cluster) {
+		if err := target.manager.Err(); err != nil {
+			if response["status"] == "ok" {
+				response["status"] = "degraded"
+				response["vault"] = err.Error()
+			}
+			clusters[target.name] = err.Error()
+		} else {
+			clusters[target.name] = "ok"
+		}
+	}
+	response["clusters"] = clusters
+	c.JSON(http.StatusOK, response)
 }
 
 func info(c *gin.Context) {
-	engine := os.Getenv("VAULT_KV_ENGINE")
-	if engine == "" {
-		engine = "secret"
+	settings := c.MustGet("config").(config.Config)
+	clusters := c.MustGet("clusters").([]*cluster)
+	var targets []gin.H
+	for _, target := range clusters {
+		targets = append(targets, gin.H{
+			"name":         target.name,
+			"vaultAddress": target.settings.Address,
+			"kvEngine":     target.settings.KVEngine,
+		})
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"version":      "0.0.2",
-		"vaultAddress": os.Getenv("VAULT_ADDR"),
-		"kvEngine":     engine,
+		"vaultAddress": clusters[0].settings.Address,
+		"kvEngine":     clusters[0].settings.KVEngine,
+		"clusters":     targets,
+		"config":       settings.Redacted(),
 	})
 }
 
 func getPaths(c *gin.Context) {
-	cache := c.MustGet("cache").(otter.Cache[string, any])
-	if cache.Has("paths") {
-		var paths, _ = cache.Get("paths")
-		c.IndentedJSON(http.StatusOK, paths)
+	query, err := parseSecretQuery(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	clusters, ok := selectClusters(c)
+	if !ok {
+		return
+	}
+	var secrets []models.AnnotatedSecret
+	if query.NeedsPolicies() || userViews(c) {
+		secrets, err = collect(c, clusters, func(ctx context.Context, target *cluster) ([]models.AnnotatedSecret, error) {
+			return visibleSecrets(c, target)
+		})
 	} else {
-		ctx := context.Background()
-		client, err := backend.AutoAuth(ctx)
-		if err != nil {
-			log.Printf("error: %v", err)
-		}
-		paths, err := GetPaths(ctx, client)
-		if err != nil {
-			log.Println(err)
+		var paths []models.Secret
+		paths, err = collect(c, clusters, cachedPaths)
+		for _, path := range paths {
+			secrets = append(secrets, models.AnnotatedSecret{Path: path})
 		}
-		cache.Set("paths", paths)
-		c.IndentedJSON(http.StatusOK, paths)
 	}
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	page, err := query.Apply(secrets)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	paths := make([]models.Secret, 0, len(page.Items))
+	for _, secret := range page.Items {
+		paths = append(paths, secret.Path)
+	}
+	writePage(c, page, paths)
 }
 
-func compressedGraphLevel(c *gin.Context) {
-	ctx := context.Background()
-	level := c.Query("l")
-	//convert level to int
-	l, _ := strconv.Atoi(level)
-	client, err := backend.AutoAuth(ctx)
-
+func cachedPaths(ctx context.Context, target *cluster) ([]models.Secret, error) {
+	if inventory := target.inventory.Load(); inventory != nil {
+		return inventory.paths, nil
+	}
+	if target.cache.Has("paths") {
+		var paths, _ = target.cache.Get("paths")
+		return paths.([]models.Secret), nil
+	}
+	client, err := target.manager.Client(ctx)
 	if err != nil {
-		log.Printf("error: %v", err)
+		return nil, err
 	}
-	paths, err := getGraphPaths(ctx, client, l)
+	paths, err := getClusterPaths(ctx, client, target)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
+	target.cache.Set("paths", paths)
+	return paths, nil
+}
 
-	root := models.CompressedGraphEntry{
-		Prefix:   paths.AbsolutePath,
-		Children: []models.CompressedGraphEntry{},
+// getClusterPaths lists the secrets of the cluster and tags them with its name.
+func getClusterPaths(ctx context.Context, client *vault.Client, target *cluster) ([]models.Secret, error) {
+	paths, err := GetPaths(ctx, client, target.settings.KVEngine)
+	for i := range paths {
+		paths[i].Cluster = target.name
 	}
+	return paths, err
+}
 
-	for _, path := range paths.Children {
-		root.Children = append(root.Children, models.CompressedGraphEntry{
-			Prefix:   path.AbsolutePath,
-			Children: appendChildren(ctx, path.AbsolutePath, path, l-1),
-		})
+func graphChildren(c *gin.Context) {
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+	if err != nil || depth < 0 || depth > maxGraphDepth {
+		badRequest(c, fmt.Errorf("depth must be a number between 0 and %d", maxGraphDepth))
+		return
 	}
-	possible := []models.CompressedGraphEntry{root}
-	for i := 0; i < l; i++ {
-		newOnes := []models.CompressedGraphEntry{}
-		for _, path := range possible {
-			println(path.Prefix)
-			newChildren := []models.CompressedGraphEntry{}
-			for _, child := range path.Children {
-				absolutePath := child.Prefix
-				if path.Prefix != "/" {
-					absolutePath = path.Prefix + "/" + child.Prefix
-				}
-				what := models.CompressedGraphEntry{
-					Prefix:   absolutePath,
-					Children: child.Children,
-				}
-				newChildren = append(newChildren, what)
-			}
-			newOnes = append(newOnes, newChildren...)
-		}
-		possible = newOnes
-		if len(possible) == 0 {
-			c.IndentedJSON(http.StatusOK, nil)
-			return
-		}
+	target, ok := selectCluster(c)
+	if !ok {
+		return
 	}
-	children := []models.CompressedGraphEntry{}
-	for _, path := range possible {
-		path.Children = nil
-		children = append(children, path)
+	graph, err := visibleGraph(c, target)
+	if err != nil {
+		vaultError(c, err)
+		return
 	}
-	root.Children = children
-	c.IndentedJSON(http.StatusOK, root)
+	var entry models.GraphEntry
+	if id := c.Query("id"); id != "" {
+		entry, ok = graph.FindById(id)
+	} else {
+		entry, ok = graph.Find(c.DefaultQuery("path", "/"))
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "path not found"})
+		return
+	}
+	c.JSON(http.StatusOK, entry.Subtree(depth))
 }
 
-func getCompressedGraph(ctx context.Context, client *vault.Client) (models.CompressedGraphEntry, error) {
-	paths, err := getGraphPaths(ctx, client, -1)
+func cachedGraph(ctx context.Context, target *cluster) (models.GraphEntry, error) {
+	if inventory := target.inventory.Load(); inventory != nil {
+		return inventory.graph, nil
+	}
+	if target.cache.Has("graph") {
+		var graph, _ = target.cache.Get("graph")
+		return graph.(models.GraphEntry), nil
+	}
+	secrets, err := cachedAnnotatedSecrets(ctx, target)
 	if err != nil {
-		log.Println(err)
+		return models.GraphEntry{}, err
 	}
+	client, err := target.manager.Client(ctx)
+	if err != nil {
+		return models.GraphEntry{}, err
+	}
+	graph := models.NewGraph(target.name, target.settings.KVEngine, secretPaths(secrets))
+	if err := aggregateGraph(ctx, client, &graph, secrets, target.settings.ReadMetadata); err != nil {
+		return models.GraphEntry{}, err
+	}
+	target.cache.Set("graph", graph)
+	return graph, nil
+}
+
+// aggregateGraph computes the aggregates of every folder, this happens once per crawl.
+// The update times are only read if readMetadata is set.
+func aggregateGraph(ctx context.Context, client *vault.Client, graph *models.GraphEntry, secrets []models.AnnotatedSecret, readMetadata bool) error {
+	var updated map[string]time.Time
+	if readMetadata {
+		var err error
+		if updated, err = GetUpdatedTimes(ctx, client, secretPaths(secrets)); err != nil {
+			return err
+		}
+	}
+	graph.Aggregate(secrets, updated)
+	return nil
+}
 
+func getCompressedGraph(ctx context.Context, paths models.GraphEntry) models.CompressedGraphEntry {
 	root := models.CompressedGraphEntry{
-		Prefix:   paths.AbsolutePath,
-		Children: []models.CompressedGraphEntry{},
+		Prefix:     paths.AbsolutePath,
+		Id:         paths.Id,
+		Children:   []models.CompressedGraphEntry{},
+		Aggregates: paths.Aggregates,
 	}
 
 	for _, path := range paths.Children {
 		root.Children = append(root.Children, models.CompressedGraphEntry{
-			Prefix:   path.Name,
-			Children: appendChildren(ctx, path.AbsolutePath, path, -1),
+			Prefix:     path.Name,
+			Id:         path.Id,
+			Children:   appendChildren(ctx, path.AbsolutePath, path, -1),
+			Aggregates: path.Aggregates,
 		})
 	}
-	return root, nil
+	return root
 }
 
 func compressedGraph(c *gin.Context) {
-	ctx := context.Background()
-	client, err := backend.AutoAuth(ctx)
-	cache := c.MustGet("cache").(otter.Cache[string, any])
-
-	if err != nil {
-		log.Printf("error: %v", err)
+	target, ok := selectCluster(c)
+	if !ok {
+		return
 	}
+	cache := target.cache
 
-	if cache.Has("compressed-graph") {
+	if userViews(c) {
+		// every caller sees a different graph, so it is not cached
+		graph, err := visibleGraph(c, target)
+		if err != nil {
+			vaultError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, getCompressedGraph(c, graph))
+	} else if inventory := target.inventory.Load(); inventory != nil {
+		c.IndentedJSON(http.StatusOK, inventory.compressed)
+	} else if cache.Has("compressed-graph") {
 		var paths, _ = cache.Get("compressed-graph")
 		c.IndentedJSON(http.StatusOK, paths)
 	} else {
-		compressedGraph, _ := getCompressedGraph(ctx, client)
+		graph, err := cachedGraph(c, target)
+		if err != nil {
+			vaultError(c, err)
+			return
+		}
+		compressedGraph := getCompressedGraph(c, graph)
 		cache.Set("compressed-graph", compressedGraph)
 		c.IndentedJSON(http.StatusOK, compressedGraph)
 	}
@@ -188,8 +309,10 @@ func appendChildren(ctx context.Context, prefix string, nodes models.GraphEntry,
 	var children []models.CompressedGraphEntry
 	for _, node := range nodes.Children {
 		children = append(children, models.CompressedGraphEntry{
-			Prefix:   node.Name,
-			Children: appendChildren(ctx, node.AbsolutePath, node, stopAtRecursion),
+			Prefix:     node.Name,
+			Id:         node.Id,
+			Children:   appendChildren(ctx, node.AbsolutePath, node, stopAtRecursion),
+			Aggregates: node.Aggregates,
 		})
 	}
 	return children
@@ -197,168 +320,413 @@ func appendChildren(ctx context.Context, prefix string, nodes models.GraphEntry,
 
 func getAnnotatedSecret(c *gin.Context) {
 	path := c.Query("path")
-	cache := c.MustGet("cache").(otter.Cache[string, any])
-	if !cache.Has("annotatedSecrets") {
-		_, err := annotateSecrets(context.Background(), cache)
+	target, ok := selectCluster(c)
+	if !ok {
+		return
+	}
+	policies, err := secretPolicies(c, target, path)
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	if policies == nil {
+		c.IndentedJSON(http.StatusNotFound, []string{})
+		return
+	}
+	if userViews(c) {
+		identity, err := lookupCaller(c, target)
 		if err != nil {
+			vaultError(c, err)
+			return
+		}
+		if !identity.hasAny(policies) {
+			c.IndentedJSON(http.StatusNotFound, []string{})
 			return
 		}
 	}
-	if cache.Has(path) {
-		var analyzedSecret, _ = cache.Get(path)
-		c.IndentedJSON(http.StatusOK, analyzedSecret)
-	} else {
-		c.IndentedJSON(http.StatusNotFound, []string{})
+	c.IndentedJSON(http.StatusOK, policies)
+}
+
+// secretPolicies returns the names of the policies with access to the secret at path, nil if there is no such secret.
+func secretPolicies(ctx context.Context, target *cluster, path string) ([]string, error) {
+	if inventory := target.inventory.Load(); inventory != nil {
+		return inventory.policies[path], nil
+	}
+	secrets, err := cachedAnnotatedSecrets(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return policyNames(secrets)[path], nil
+}
+
+// policyNames maps the path of every secret to the names of the policies with access to it.
+func policyNames(secrets []models.AnnotatedSecret) map[string][]string {
+	names := make(map[string][]string, len(secrets))
+	for _, secret := range secrets {
+		policies := []string{}
+		for _, policy := range secret.Policies {
+			if !slices.Contains(policies, policy.Name) {
+				policies = append(policies, policy.Name)
+			}
+		}
+		names[secret.Path.Path] = policies
 	}
+	return names
 }
 
-func annotateSecrets(ctx context.Context, cache otter.Cache[string, any]) ([]models.AnnotatedSecret, error) {
-	client, err := backend.AutoAuth(ctx)
+func annotateSecrets(ctx context.Context, client *vault.Client, target *cluster) ([]models.AnnotatedSecret, error) {
+	policies, err := GetPolicies(ctx, client)
 	if err != nil {
-		log.Printf("could not authenticate: %v", err)
+		logging.FromContext(ctx).Error("could not get the policies", "cluster", target.name, logging.Error(err))
 		return nil, err
 	}
-	paths, err := GetPaths(ctx, client)
+	return annotateWith(ctx, client, target, policies)
+}
+
+// annotateWith annotates the secrets of the cluster with the policies granting access to them.
+func annotateWith(ctx context.Context, client *vault.Client, target *cluster, policies []models.Policy) ([]models.AnnotatedSecret, error) {
+	paths, err := getClusterPaths(ctx, client, target)
 	if err != nil {
-		log.Printf("could not get paths: %v", err)
+		logging.FromContext(ctx).Error("could not get the paths", "cluster", target.name, logging.Error(err))
 		return nil, err
 	}
-	policies, _ := GetPolicies(ctx, client)
 	var analyzedPaths = []models.AnnotatedSecret{}
 	for _, path := range paths {
-		var accessiblePolicies []models.Policy
-		for _, policy := range policies {
-			if policy.HasAccessTo(path.Path) {
-				accessiblePolicies = append(accessiblePolicies, policy)
-			}
-		}
-		for _, policy := range accessiblePolicies {
-			if !cache.Has(path.Path) {
-				cache.Set(path.Path, []string{policy.Name})
-			} else {
-				entry, _ := cache.Get(path.Path)
-				cached := entry.([]string)
-				cached = append(cached, policy.Name)
-				// make a set out of the cached values
-				set := make(map[string]struct{})
-				for _, value := range cached {
-					set[value] = struct{}{}
-				}
-				cached = []string{}
-				for key := range set {
-					cached = append(cached, key)
-				}
-				cache.Set(path.Path, cached)
-			}
-		}
-		analyzedPaths = append(analyzedPaths, models.AnnotatedSecret{Path: path, Policies: accessiblePolicies})
+		analyzedPaths = append(analyzedPaths, models.AnnotatedSecret{Path: path, Policies: accessibleBy(path.Path, policies)})
 	}
-
-	cache.Set("annotatedSecrets", analyzedPaths)
 	return analyzedPaths, nil
 }
 
+// secretPaths returns the paths of the secrets.
+func secretPaths(secrets []models.AnnotatedSecret) []models.Secret {
+	paths := make([]models.Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		paths = append(paths, secret.Path)
+	}
+	return paths
+}
+
 func getAnnotatedSecrets(c *gin.Context) {
-	cache := c.MustGet("cache").(otter.Cache[string, any])
-	if cache.Has("annotatedSecrets") {
-		var analyzedSecret, _ = cache.Get("annotatedSecrets")
-		c.IndentedJSON(http.StatusOK, analyzedSecret)
-	} else {
-		response, _ := annotateSecrets(context.Background(), cache)
-		cache.Set("annotatedSecrets", response)
-		c.IndentedJSON(http.StatusOK, response)
+	query, err := parseSecretQuery(c)
+	if err != nil {
+		badRequest(c, err)
+		return
+	}
+	clusters, ok := selectClusters(c)
+	if !ok {
+		return
+	}
+	secrets, err := collect(c, clusters, func(ctx context.Context, target *cluster) ([]models.AnnotatedSecret, error) {
+		return visibleSecrets(c, target)
+	})
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	page, err := query.Apply(secrets)
+	if err != nil {
+		badRequest(c, err)
+		return
 	}
+	writePage(c, page, page.Items)
 }
 
-func CacheProvider() gin.HandlerFunc {
+func cachedAnnotatedSecrets(ctx context.Context, target *cluster) ([]models.AnnotatedSecret, error) {
+	if inventory := target.inventory.Load(); inventory != nil {
+		return inventory.secrets, nil
+	}
+	if target.cache.Has("annotatedSecrets") {
+		var analyzedSecrets, _ = target.cache.Get("annotatedSecrets")
+		return analyzedSecrets.([]models.AnnotatedSecret), nil
+	}
+	client, err := target.manager.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response, err := annotateSecrets(ctx, client, target)
+	if err != nil {
+		return nil, err
+	}
+	target.cache.Set("annotatedSecrets", response)
+	return response, nil
+}
+
+// vaultError responds with 503 if Vault cannot be used right now because secretpaths is misconfigured
+// or Vault is unreachable, with 504 if the request timed out and with 502 if Vault rejected or failed a request.
+func vaultError(c *gin.Context, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the caller is gone, there is nobody to respond to
+		logging.FromContext(c.Request.Context()).Info("request cancelled by the caller")
+		c.AbortWithStatus(statusClientClosedRequest)
+		return
+	}
+	logging.FromContext(c.Request.Context()).Error("vault request failed", logging.Error(err))
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": err.Error()})
+		return
+	}
+	status := http.StatusBadGateway
+	if errors.Is(err, errUnauthenticated) {
+		status = http.StatusUnauthorized
+	} else if errors.Is(err, backend.ErrMisconfigured) || errors.Is(err, backend.ErrUnreachable) {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// newCache returns the cache of lookups of a cluster, whose entries expire after ttl.
+func newCache(ttl time.Duration) otter.Cache[string, any] {
 	cache, err := otter.MustBuilder[string, any](10_000).
 		CollectStats().
 		Cost(func(key string, value any) uint32 {
 			return 1
 		}).
-		WithTTL(5 * time.Minute).
+		WithTTL(ttl).
 		Build()
 	if err != nil {
 		panic(err)
 	}
+	return cache
+}
+
+// RequestTimeout cancels the requests to Vault a handler makes once the request took longer than timeout.
+// The stream of changes is left out, it stays open until the caller goes away.
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Set("cache", cache)
+		if c.Request.URL.Path == "/v1/changes" {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
 		c.Next()
 	}
 }
 
-func UpdateCaches(c *gin.Context) {
-	log.Printf("updating caches")
-	cache := c.MustGet("cache").(otter.Cache[string, any])
-	client, err := backend.AutoAuth(context.Background())
+// ConfigProvider makes the configuration available to the handlers.
+func ConfigProvider(settings config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("config", settings)
+		c.Next()
+	}
+}
+
+// updateCluster crawls the cluster and replaces its inventory, step is called with the name of every stage of the crawl.
+// It returns the number of secrets, a cluster which fails keeps its previous inventory. Events applied during the crawl
+// are applied to the new inventory again.
+func updateCluster(ctx context.Context, target *cluster, step func(string)) (secrets int, err error) {
+	start := time.Now()
+	var crawl metrics.Crawl
+	ctx, span := tracing.Start(ctx, "crawl", attribute.String("vault.cluster", target.name))
+	defer func() {
+		metrics.ObserveCrawl(target.name, time.Since(start), crawl, err)
+		span.SetAttributes(attribute.Int("secretpaths.secrets", secrets))
+		tracing.End(span, err)
+	}()
+	progress := step
+	step = func(name string) {
+		span.AddEvent(name)
+		progress(name)
+	}
+	var result *snapshot
+	target.crawlStarted()
+	defer func() {
+		target.crawlFinished(result)
+	}()
+	step("login")
+	client, err := target.manager.Client(ctx)
 	if err != nil {
-		log.Printf("error: %v", err)
+		return 0, err
 	}
-	compressedGraph, _ := getCompressedGraph(context.Background(), client)
-	cache.Set("compressed-graph", compressedGraph)
-	annotatedSecrets, _ := annotateSecrets(context.Background(), cache)
-	cache.Set("annotatedSecrets", annotatedSecrets)
-	paths, err := GetPaths(c, client)
+	step("policies")
+	policies, unparsed, err := listPolicies(ctx, client)
 	if err != nil {
-		cache.Set("paths", paths)
+		return 0, err
 	}
-}
-
-func callUpdateEndpoint() (map[string]interface{}, error) {
-	// Create a request to the /hello endpoint
-	resp, err := http.Get("http://localhost:8081/update")
+	step("secrets")
+	annotatedSecrets, err := annotateWith(ctx, client, target, policies)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer resp.Body.Close()
-
-	// Decode the JSON response
-	var response map[string]interface{}
-
-	return response, nil
+	// the mount is only listed once, the paths and the graph are derived from the secrets
+	paths := secretPaths(annotatedSecrets)
+	step("graph")
+	graph := models.NewGraph(target.name, target.settings.KVEngine, paths)
+	if err := aggregateGraph(ctx, client, &graph, annotatedSecrets, target.settings.ReadMetadata); err != nil {
+		return len(annotatedSecrets), fmt.Errorf("could not aggregate the graph: %w", err)
+	}
+	result = &snapshot{
+		secrets:    annotatedSecrets,
+		graph:      graph,
+		compressed: getCompressedGraph(ctx, graph),
+		paths:      paths,
+		policies:   policyNames(annotatedSecrets),
+		acl:        policies,
+		crawledAt:  time.Now(),
+	}
+	crawl = inventory(annotatedSecrets, graph, target.settings.KVEngine)
+	crawl.Governance = models.Assess(annotatedSecrets, policies, len(unparsed), target.maxReaders)
+	return len(annotatedSecrets), nil
 }
 
-func main() {
+// newRouter registers the endpoints of the API, each behind the role it requires.
+func newRouter(settings config.Config, clusters []*cluster, changes *changeFeed, refresh *refresher) *gin.Engine {
 	router := gin.New()
-	scheduler, err := gocron.NewScheduler()
+	// handlers pass the gin context on as context, so it has to carry the deadline of the request
+	router.ContextWithFallback = true
 	router.Use(
-		gin.LoggerWithWriter(gin.DefaultWriter, "/v1/healthz"),
-		gin.Recovery(),
+		logging.Middleware(),
+		logging.Recovery(),
+		metrics.Middleware(),
+		tracing.Middleware(),
+		RequestTimeout(settings.RequestTimeout),
 	)
 
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"*"},
-		AllowHeaders:     []string{"Origin"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-	router.Use(CacheProvider())
-	router.GET("/v1/info", info)
+	router.Use(corsMiddleware(settings.API.CORSOrigins))
+	router.Use(ConfigProvider(settings))
+	router.Use(ClusterProvider(clusters))
 	router.GET("/v1/healthz", healthz)
-	router.GET("/v1/paths", getPaths)
-	router.GET("/v1/level", compressedGraphLevel)
-	router.GET("/v1/graph", compressedGraph)
-	router.GET("/v1/policies", getPolicies)
-	router.GET("/v1/annotated", getAnnotatedSecret)
-	router.GET("/v1/annotatedSecrets", getAnnotatedSecrets)
-	router.GET("/update", func(c *gin.Context) {
-		UpdateCaches(c)
-	})
+	router.GET("/v1/livez", livez)
+	router.GET("/v1/readyz", readyz)
+	router.GET("/metrics", metrics.Handler())
+	router.GET("/v1/login/oidc", oidcLogin)
+	router.GET("/v1/login/oidc/callback", oidcCallback)
 
-	job, err := scheduler.NewJob(
-		gocron.DurationJob(
-			3*time.Minute,
-		),
-		gocron.NewTask(callUpdateEndpoint),
-	)
+	access := newGuard(settings.API, clusters)
+	viewer := router.Group("/", access.require(apiauth.Viewer))
+	viewer.GET("/v1/info", info)
+	viewer.GET("/v1/paths", getPaths)
+	viewer.GET("/v1/graph", compressedGraph)
+	viewer.GET("/v1/graph/children", graphChildren)
+	viewer.GET("/v1/access", getAccess)
+	viewer.GET("/v1/changes", changes.getChanges)
+	auditor := router.Group("/", access.require(apiauth.Auditor))
+	auditor.GET("/v1/policies", getPolicies)
+	auditor.GET("/v1/annotated", getAnnotatedSecret)
+	auditor.GET("/v1/annotatedSecrets", getAnnotatedSecrets)
+	auditor.GET("/v1/export/:format", exportGraph)
+	admin := router.Group("/", access.require(apiauth.Admin))
+	admin.POST("/v1/refresh", refresh.postRefresh)
+	admin.GET("/v1/refresh/:id", refresh.getRefresh)
+	return router
+}
 
-	log.Printf("job: %v", job)
-	scheduler.Start()
+// inventory counts the secrets, folders and policies with access by mount.
+func inventory(secrets []models.AnnotatedSecret, graph models.GraphEntry, kvEngine string) metrics.Crawl {
+	crawl := metrics.Crawl{
+		Secrets:  map[string]int{},
+		Folders:  map[string]int{kvEngine: graph.Folders()},
+		Policies: map[string]int{},
+	}
+	policies := map[string]map[string]bool{}
+	for _, secret := range secrets {
+		mount := secret.Path.Mount
+		crawl.Secrets[mount]++
+		if policies[mount] == nil {
+			policies[mount] = map[string]bool{}
+		}
+		for _, policy := range secret.Policies {
+			policies[mount][policy.Name] = true
+		}
+	}
+	for mount, names := range policies {
+		crawl.Policies[mount] = len(names)
+	}
+	return crawl
+}
 
-	err = router.Run(":8081")
-	if err != nil {
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
+	settings, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if err := logging.Setup(os.Stderr, settings.Logging.Format, settings.Logging.Level, settings.Logging.Paths); err != nil {
+		log.Fatalf("could not set up logging: %v", err)
+	}
+	if os.Getenv(gin.EnvGinMode) == "" {
+		// the routes gin prints in debug mode are not structured
+		gin.SetMode(gin.ReleaseMode)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing, err := tracing.Setup(ctx, settings.Tracing.Exporter)
+	if err != nil {
+		slog.Error("could not set up tracing", logging.Error(err))
+		os.Exit(1)
+	}
+	clusters := newClusters(settings)
+	changes := newChangeFeed(ctx)
+	for _, target := range clusters {
+		target.feed = changes
+		target.manager.Start(ctx)
+	}
+
+	refresh := newRefresher(ctx, clusters)
+	router := newRouter(settings, clusters, changes, refresh)
+	scheduler, err := gocron.NewScheduler()
+	if err != nil {
+		slog.Error("could not create the scheduler", logging.Error(err))
+		os.Exit(1)
+	}
+	if err := refresh.schedule(scheduler); err != nil {
+		slog.Error("could not schedule the crawls", logging.Error(err))
+		os.Exit(1)
+	}
+	scheduler.Start()
+	// subscribing before the first crawl starts keeps the events from slipping through between the two
+	refresh.watchEvents(ctx)
+	// the first crawl makes the clusters ready, it ignores the windows
+	refresh.start("startup", clusters)
+
+	server := &http.Server{Addr: settings.Listen, Handler: router}
+	served := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "address", settings.Listen)
+		served <- server.ListenAndServe()
+	}()
+	code := 0
+	select {
+	case err := <-served:
+		slog.Error("could not serve the API", logging.Error(err))
+		code = 1
+	case <-ctx.Done():
+		slog.Info("shutting down", "timeout", settings.ShutdownTimeout)
+	}
+	// a second signal stops the server right away
+	stop()
+	shutdown(settings.ShutdownTimeout, server, scheduler, refresh, clusters, shutdownTracing)
+	os.Exit(code)
+}
+
+// shutdown stops the scheduler, lets the in-flight requests finish, waits for the cancelled crawl,
+// revokes the tokens and exports the last spans, all within the timeout.
+func shutdown(timeout time.Duration, server *http.Server, scheduler gocron.Scheduler, refresh *refresher,
+	clusters []*cluster, shutdownTracing func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := scheduler.Shutdown(); err != nil {
+		slog.Error("could not stop the scheduler", logging.Error(err))
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("could not finish the in-flight requests", logging.Error(err))
+		_ = server.Close()
+	}
+	if err := refresh.stop(ctx); err != nil {
+		slog.Error("could not wait for the crawl", logging.Error(err))
+	}
+	for _, target := range clusters {
+		if err := target.manager.Close(logging.With(ctx, "cluster", target.name)); err != nil {
+			slog.Error("could not close the connection", "cluster", target.name, logging.Error(err))
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("could not export the last spans", logging.Error(err))
+	}
 }
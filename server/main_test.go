@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"secretpaths/backend"
+	"secretpaths/config"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serverToken is the token the clusters of the tests log in with.
+const serverToken = "s.server"
+
+// fakeVault serves the endpoints a crawl and the handlers use from a set of secrets, policies and tokens,
+// which the tests can change while it runs.
+type fakeVault struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	secrets []string
+	// policies maps the names of the policies to their HCL.
+	policies map[string]string
+	// tokens maps the tokens of callers to their policies, lookups of other tokens than these and
+	// the one of the server are rejected.
+	tokens map[string][]string
+	// brokenLookups makes the lookups of the tokens of callers fail, while the server can still log in.
+	brokenLookups bool
+	// uninitialized makes the health check fail the way it does before Vault is initialized.
+	uninitialized bool
+	calls         map[string]int
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	vault := &fakeVault{
+		secrets: []string{"/team-a/db", "/team-a/api/key", "/team-b/db"},
+		policies: map[string]string{
+			"team-a": `path "team-a/*" { capabilities = ["read"] }`,
+			"team-b": `path "team-b/*" { capabilities = ["read", "update"] }`,
+		},
+		tokens: map[string][]string{"s.alice": {"default", "team-a"}, "s.bob": {"team-b"}},
+		calls:  map[string]int{},
+	}
+	vault.Server = httptest.NewServer(http.HandlerFunc(vault.serve))
+	t.Cleanup(vault.Close)
+	return vault
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.calls[r.URL.Path]++
+	w.Header().Set("Content-Type", "application/json")
+	respond := func(status int, body any) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+	list := r.Method == "LIST" || r.URL.Query().Get("list") == "true"
+	switch path := r.URL.Path; {
+	case path == "/v1/sys/health" && v.uninitialized:
+		// 501 like Vault, which unlike the other server errors the client does not retry
+		respond(http.StatusNotImplemented, map[string]any{"initialized": false, "sealed": true})
+	case path == "/v1/sys/health":
+		respond(http.StatusOK, map[string]any{"initialized": true, "sealed": false})
+	case path == "/v1/auth/token/lookup-self":
+		token := r.Header.Get("X-Vault-Token")
+		if token == serverToken {
+			respond(http.StatusOK, map[string]any{"data": map[string]any{"ttl": 0, "policies": []string{"root"}}})
+			return
+		}
+		if v.brokenLookups {
+			// not a server error, which the client would retry for seconds
+			respond(http.StatusBadRequest, map[string]any{"errors": []string{"lookup failed"}})
+			return
+		}
+		policies, ok := v.tokens[token]
+		if !ok {
+			respond(http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		respond(http.StatusOK, map[string]any{"data": map[string]any{
+			"ttl": 0, "display_name": "token-" + strings.TrimPrefix(token, "s."), "policies": policies,
+		}})
+	case strings.TrimSuffix(path, "/") == "/v1/sys/policies/acl" && list:
+		names := []string{"root"}
+		for name := range v.policies {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		respond(http.StatusOK, map[string]any{"data": map[string]any{"keys": names}})
+	case strings.HasPrefix(path, "/v1/sys/policies/acl/"):
+		name := strings.TrimPrefix(path, "/v1/sys/policies/acl/")
+		policy, ok := v.policies[name]
+		if !ok {
+			respond(http.StatusNotFound, map[string]any{"errors": []string{}})
+			return
+		}
+		respond(http.StatusOK, map[string]any{"data": map[string]any{"name": name, "policy": policy}})
+	case strings.HasPrefix(path, "/v1/secret/metadata/") && list:
+		keys := v.keys(strings.TrimPrefix(path, "/v1/secret/metadata"))
+		if len(keys) == 0 {
+			respond(http.StatusNotFound, map[string]any{"errors": []string{}})
+			return
+		}
+		respond(http.StatusOK, map[string]any{"data": map[string]any{"keys": keys}})
+	case strings.HasPrefix(path, "/v1/secret/metadata/"):
+		respond(http.StatusOK, map[string]any{"data": map[string]any{"updated_time": "2024-05-01T12:00:00Z"}})
+	default:
+		respond(http.StatusNotFound, map[string]any{"errors": []string{}})
+	}
+}
+
+// keys lists the folder the way Vault does, folders end in a slash.
+func (v *fakeVault) keys(folder string) []string {
+	folder = "/" + strings.Trim(folder, "/") + "/"
+	folder = strings.Replace(folder, "//", "/", 1)
+	var keys []string
+	for _, secret := range v.secrets {
+		if !strings.HasPrefix(secret, folder) {
+			continue
+		}
+		key, _, nested := strings.Cut(strings.TrimPrefix(secret, folder), "/")
+		if nested {
+			key += "/"
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// change runs update while no request is served, so the tests can change what Vault returns.
+func (v *fakeVault) change(update func(*fakeVault)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	update(v)
+}
+
+func (v *fakeVault) count(path string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.calls[path]
+}
+
+// newTestCluster returns a cluster of the Vault, which the server logs in to with serverToken.
+func newTestCluster(name string, vault *fakeVault) *cluster {
+	settings := config.Default().Vault
+	settings.Address = vault.URL
+	return &cluster{
+		name:     name,
+		settings: settings,
+		manager:  backend.NewManagerWith(backend.Connection{Address: vault.URL}, backend.TokenAuth{Token: serverToken}),
+		cache:    newCache(time.Minute),
+	}
+}
+
+// crawl crawls the cluster and fails the test if the crawl fails.
+func crawl(t *testing.T, target *cluster) {
+	t.Helper()
+	if _, err := updateCluster(context.Background(), target, func(string) {}); err != nil {
+		t.Fatalf("could not crawl %s: %v", target.name, err)
+	}
+}
+
+// newTestServer serves the API for the clusters, configure changes the default configuration.
+func newTestServer(t *testing.T, clusters []*cluster, configure func(*config.Config)) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	settings := config.Default()
+	if configure != nil {
+		configure(&settings)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := newChangeFeed(ctx)
+	for _, target := range clusters {
+		target.feed = changes
+	}
+	refresh := newRefresher(ctx, clusters)
+	server := httptest.NewServer(newRouter(settings, clusters, changes, refresh))
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+		_ = refresh.stop(context.Background())
+	})
+	return server
+}
+
+// call sends a request to the server with the headers, given as name and value pairs, and decodes the JSON
+// response into result if it is set.
+func call(t *testing.T, method, url string, result any, headers ...string) *http.Response {
+	t.Helper()
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != nil && response.StatusCode < 300 {
+		if err := json.Unmarshal(body, result); err != nil {
+			t.Fatalf("could not decode %s: %v", body, err)
+		}
+	}
+	return response
+}
@@ -0,0 +1,243 @@
+// Package metrics collects the Prometheus metrics of secretpaths, served at /metrics.
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/maypok86/otter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"secretpaths/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const namespace = "secretpaths"
+
+// Registry holds the metrics of secretpaths and of the Go runtime.
+var Registry = prometheus.NewRegistry()
+
+var (
+	crawlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "crawl_duration_seconds",
+		Help:      "Duration of the crawls of a cluster by result.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200},
+	}, []string{"cluster", "result"})
+	lastCrawl = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_crawl_timestamp_seconds",
+		Help:      "Time of the last successful crawl of a cluster.",
+	}, []string{"cluster"})
+	secrets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets",
+		Help:      "Number of secrets found by the last crawl.",
+	}, []string{"cluster", "mount"})
+	folders = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "folders",
+		Help:      "Number of folders found by the last crawl.",
+	}, []string{"cluster", "mount"})
+	policies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policies",
+		Help:      "Number of policies granting access to secrets of the mount.",
+	}, []string{"cluster", "mount"})
+	vaultRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vault_requests_total",
+		Help:      "Requests to Vault by endpoint and status code.",
+	}, []string{"cluster", "endpoint", "method", "code"})
+	vaultErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vault_request_errors_total",
+		Help:      "Requests to Vault which failed or were answered with an error status.",
+	}, []string{"cluster", "endpoint"})
+	vaultDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vault_request_duration_seconds",
+		Help:      "Latency of the requests to Vault by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cluster", "endpoint"})
+	unreadSecrets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_without_readers",
+		Help:      "Number of secrets no policy grants read access to.",
+	}, []string{"cluster", "mount"})
+	overexposedSecrets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_overexposed",
+		Help:      "Number of secrets more policies than the configured maximum grant read access to.",
+	}, []string{"cluster", "mount"})
+	wildcardPolicies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policies_root_wildcard",
+		Help:      "Number of policies with a rule whose first path segment is a wildcard.",
+	}, []string{"cluster"})
+	sudoPolicies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policies_sudo",
+		Help:      "Number of policies granting sudo.",
+	}, []string{"cluster"})
+	unparsedPolicies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policies_unparsed",
+		Help:      "Number of policies which could not be read or parsed.",
+	}, []string{"cluster"})
+	events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Events of Vault applied to the inventory of a cluster by kind.",
+	}, []string{"cluster", "kind"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the requests to the API by route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		crawlDuration, lastCrawl, secrets, folders, policies,
+		unreadSecrets, overexposedSecrets, wildcardPolicies, sudoPolicies, unparsedPolicies,
+		vaultRequests, vaultErrors, vaultDuration, events, httpDuration,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() gin.HandlerFunc {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	return gin.WrapH(handler)
+}
+
+// Middleware measures the latency of every request by route, unmatched requests are counted as the route "unmatched"
+// so scanners cannot create a series per path.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Crawl is the inventory found by a successful crawl of a cluster, by mount.
+type Crawl struct {
+	Secrets    map[string]int
+	Folders    map[string]int
+	Policies   map[string]int
+	Governance models.Governance
+}
+
+// ObserveCrawl records the duration of a crawl of the cluster and, if it succeeded, the inventory it found.
+func ObserveCrawl(cluster string, duration time.Duration, crawl Crawl, err error) {
+	if err != nil {
+		crawlDuration.WithLabelValues(cluster, "failure").Observe(duration.Seconds())
+		return
+	}
+	crawlDuration.WithLabelValues(cluster, "success").Observe(duration.Seconds())
+	lastCrawl.WithLabelValues(cluster).SetToCurrentTime()
+	// mounts which disappeared must not keep their last value
+	for _, gauge := range []*prometheus.GaugeVec{secrets, folders, policies, unreadSecrets, overexposedSecrets} {
+		gauge.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	}
+	for mount, count := range crawl.Secrets {
+		secrets.WithLabelValues(cluster, mount).Set(float64(count))
+	}
+	for mount, count := range crawl.Folders {
+		folders.WithLabelValues(cluster, mount).Set(float64(count))
+	}
+	for mount, count := range crawl.Policies {
+		policies.WithLabelValues(cluster, mount).Set(float64(count))
+	}
+	for mount, count := range crawl.Governance.Unread {
+		unreadSecrets.WithLabelValues(cluster, mount).Set(float64(count))
+	}
+	for mount, count := range crawl.Governance.Overexposed {
+		overexposedSecrets.WithLabelValues(cluster, mount).Set(float64(count))
+	}
+	wildcardPolicies.WithLabelValues(cluster).Set(float64(crawl.Governance.Wildcard))
+	sudoPolicies.WithLabelValues(cluster).Set(float64(crawl.Governance.Sudo))
+	unparsedPolicies.WithLabelValues(cluster).Set(float64(crawl.Governance.Unparsed))
+}
+
+// ObserveEvent counts an event of Vault which was applied to the inventory of the cluster.
+func ObserveEvent(cluster, kind string) {
+	events.WithLabelValues(cluster, kind).Inc()
+}
+
+// RegisterCache exposes the hits, misses and evictions of the cache of a cluster.
+func RegisterCache(cluster string, cache otter.Cache[string, any]) {
+	labels := prometheus.Labels{"cluster": cluster}
+	counter := func(name, help string, value func(otter.Stats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(value(cache.Stats()))
+		})
+	}
+	Registry.MustRegister(
+		counter("cache_hits_total", "Lookups which found an entry in the cache.", otter.Stats.Hits),
+		counter("cache_misses_total", "Lookups which did not find an entry in the cache.", otter.Stats.Misses),
+		counter("cache_evictions_total", "Entries evicted from the cache.", otter.Stats.EvictedCount),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "cache_entries",
+			Help:        "Entries in the cache.",
+			ConstLabels: labels,
+		}, func() float64 {
+			return float64(cache.Size())
+		}),
+	)
+}
+
+// InstrumentVault returns a function wrapping the transport of the Vault client of a cluster,
+// which counts the requests by endpoint.
+func InstrumentVault(cluster string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripper(func(request *http.Request) (*http.Response, error) {
+			endpoint := Endpoint(request.URL.Path)
+			start := time.Now()
+			response, err := next.RoundTrip(request)
+			vaultDuration.WithLabelValues(cluster, endpoint).Observe(time.Since(start).Seconds())
+			code := "error"
+			if err == nil {
+				code = strconv.Itoa(response.StatusCode)
+			}
+			vaultRequests.WithLabelValues(cluster, endpoint, request.Method, code).Inc()
+			if err != nil || response.StatusCode >= http.StatusBadRequest {
+				vaultErrors.WithLabelValues(cluster, endpoint).Inc()
+			}
+			return response, err
+		})
+	}
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (r roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	return r(request)
+}
+
+// Endpoint returns the endpoint of a Vault API path without the names of secrets and policies,
+// e.g. secret/metadata for /v1/secret/metadata/team-a/db, which keeps the number of series bounded.
+func Endpoint(path string) string {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(path, "/v1/"), "/"), "/")
+	keep := 2
+	if segments[0] == "sys" || segments[0] == "auth" || segments[0] == "identity" {
+		keep = 3
+	}
+	return strings.Join(segments[:min(keep, len(segments))], "/")
+}
@@ -0,0 +1,95 @@
+package metrics_test
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"secretpaths/metrics"
+	"secretpaths/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpoint(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/v1/secret/metadata/team-a/db":   "secret/metadata",
+		"/v1/secret/metadata/":            "secret/metadata",
+		"/v1/sys/policies/acl/reader":     "sys/policies/acl",
+		"/v1/sys/policies/acl":            "sys/policies/acl",
+		"/v1/auth/token/lookup-self":      "auth/token/lookup-self",
+		"/v1/auth/kubernetes/login":       "auth/kubernetes/login",
+		"/v1/identity/entity/id/12345678": "identity/entity/id",
+		"/v1/sys/health":                  "sys/health",
+	} {
+		if endpoint := metrics.Endpoint(path); endpoint != expected {
+			t.Errorf("%s: expected: %s, got: %s", path, expected, endpoint)
+		}
+	}
+}
+
+func TestInstrumentVault(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/forbidden") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer vault.Close()
+	client := &http.Client{Transport: metrics.InstrumentVault("instrumented")(http.DefaultTransport)}
+
+	for _, path := range []string{"/v1/secret/metadata/a", "/v1/secret/metadata/b", "/v1/secret/data/forbidden"} {
+		response, err := client.Get(vault.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	expected := `
+# HELP secretpaths_vault_request_errors_total Requests to Vault which failed or were answered with an error status.
+# TYPE secretpaths_vault_request_errors_total counter
+secretpaths_vault_request_errors_total{cluster="instrumented",endpoint="secret/data"} 1
+# HELP secretpaths_vault_requests_total Requests to Vault by endpoint and status code.
+# TYPE secretpaths_vault_requests_total counter
+secretpaths_vault_requests_total{cluster="instrumented",code="200",endpoint="secret/metadata",method="GET"} 2
+secretpaths_vault_requests_total{cluster="instrumented",code="403",endpoint="secret/data",method="GET"} 1
+`
+	if err := testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "secretpaths_vault_requests_total", "secretpaths_vault_request_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestObserveCrawl(t *testing.T) {
+	metrics.ObserveCrawl("observed", time.Second, metrics.Crawl{
+		Secrets:  map[string]int{"secret": 3, "old": 1},
+		Folders:  map[string]int{"secret": 2},
+		Policies: map[string]int{"secret": 4},
+	}, nil)
+	metrics.ObserveCrawl("observed", time.Second, metrics.Crawl{
+		Secrets:    map[string]int{"secret": 5},
+		Governance: models.Governance{Unread: map[string]int{"secret": 0}, Overexposed: map[string]int{"secret": 2}, Sudo: 1},
+	}, nil)
+	metrics.ObserveCrawl("observed", time.Minute, metrics.Crawl{}, errors.New("vault unreachable"))
+
+	expected := `
+# HELP secretpaths_secrets Number of secrets found by the last crawl.
+# TYPE secretpaths_secrets gauge
+secretpaths_secrets{cluster="observed",mount="secret"} 5
+# HELP secretpaths_secrets_overexposed Number of secrets more policies than the configured maximum grant read access to.
+# TYPE secretpaths_secrets_overexposed gauge
+secretpaths_secrets_overexposed{cluster="observed",mount="secret"} 2
+# HELP secretpaths_secrets_without_readers Number of secrets no policy grants read access to.
+# TYPE secretpaths_secrets_without_readers gauge
+secretpaths_secrets_without_readers{cluster="observed",mount="secret"} 0
+# HELP secretpaths_policies_sudo Number of policies granting sudo.
+# TYPE secretpaths_policies_sudo gauge
+secretpaths_policies_sudo{cluster="observed"} 1
+`
+	if err := testutil.GatherAndCompare(metrics.Registry, strings.NewReader(expected), "secretpaths_secrets", "secretpaths_secrets_overexposed", "secretpaths_secrets_without_readers", "secretpaths_policies_sudo"); err != nil {
+		t.Error(err)
+	}
+	if count := testutil.CollectAndCount(metrics.Registry, "secretpaths_crawl_duration_seconds"); count != 2 {
+		t.Errorf("expected a series for successful and failed crawls, got %d", count)
+	}
+}
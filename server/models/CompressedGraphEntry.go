@@ -2,5 +2,7 @@ package models
 
 type CompressedGraphEntry struct {
 	Prefix   string                 `json:"prefix"`
+	Id       string                 `json:"id"`
 	Children []CompressedGraphEntry `json:"children,omitempty"`
+	Aggregates
 }
@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// Aggregates summarize the subtree below a node of the graph.
+type Aggregates struct {
+	Secrets       int        `json:"secrets"`
+	Policies      int        `json:"policies"`
+	MaxCapability string     `json:"maxCapability,omitempty"`
+	OldestUpdate  *time.Time `json:"oldestUpdate,omitempty"`
+}
+
+// Aggregate computes the aggregates of every node in the tree from the annotated secrets.
+// updated maps the path of a secret to the time it was last written and may be nil.
+func (g *GraphEntry) Aggregate(secrets []AnnotatedSecret, updated map[string]time.Time) {
+	byPath := make(map[string]AnnotatedSecret, len(secrets))
+	for _, secret := range secrets {
+		byPath[secret.Path.Path] = secret
+	}
+	g.aggregate(byPath, updated)
+}
+
+func (g *GraphEntry) aggregate(secrets map[string]AnnotatedSecret, updated map[string]time.Time) map[string]struct{} {
+	policies := make(map[string]struct{})
+	g.Aggregates = Aggregates{}
+	if !g.Folder {
+		g.Secrets = 1
+		var capabilities []string
+		for _, policy := range secrets[g.AbsolutePath].Policies {
+			policies[policy.Name] = struct{}{}
+			capabilities = append(capabilities, policy.CapabilitiesFor(g.AbsolutePath)...)
+		}
+		g.Policies = len(policies)
+		g.MaxCapability = MaxCapability(capabilities...)
+		if timestamp, ok := updated[g.AbsolutePath]; ok {
+			g.OldestUpdate = &timestamp
+		}
+		return policies
+	}
+	for i := range g.Children {
+		child := &g.Children[i]
+		for policy := range child.aggregate(secrets, updated) {
+			policies[policy] = struct{}{}
+		}
+		g.Secrets += child.Secrets
+		g.MaxCapability = MaxCapability(g.MaxCapability, child.MaxCapability)
+		if child.OldestUpdate != nil && (g.OldestUpdate == nil || child.OldestUpdate.Before(*g.OldestUpdate)) {
+			g.OldestUpdate = child.OldestUpdate
+		}
+	}
+	g.Policies = len(policies)
+	return policies
+}
+
+// Restrict returns a copy of the tree with only the given secrets and the folders containing them.
+// The aggregates are computed over these secrets, keeping the times they were last written.
+func (g GraphEntry) Restrict(secrets []AnnotatedSecret) GraphEntry {
+	keep := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		keep[secret.Path.Path] = true
+	}
+	updated := make(map[string]time.Time)
+	restricted, _ := g.restrict(keep, updated)
+	restricted.Aggregate(secrets, updated)
+	return restricted
+}
+
+func (g GraphEntry) restrict(keep map[string]bool, updated map[string]time.Time) (GraphEntry, bool) {
+	if !g.Folder {
+		if g.OldestUpdate != nil {
+			updated[g.AbsolutePath] = *g.OldestUpdate
+		}
+		return g, keep[g.AbsolutePath]
+	}
+	children := make([]GraphEntry, 0, len(g.Children))
+	for _, child := range g.Children {
+		if restricted, ok := child.restrict(keep, updated); ok {
+			children = append(children, restricted)
+		}
+	}
+	g.Children = children
+	return g, len(children) > 0
+}
@@ -1,8 +1,194 @@
 package models
 
+import (
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+)
+
+// nodeNamespace is the namespace of the name based UUIDs identifying nodes of the graph.
+var nodeNamespace = uuid.MustParse("6acdd49e-2ef4-41c0-bacc-a055222b6159")
+
 type GraphEntry struct {
 	AbsolutePath string       `json:"path"`
 	Id           string       `json:"id"`
 	Name         string       `json:"name"`
+	Folder       bool         `json:"folder"`
 	Children     []GraphEntry `json:"children"`
+	Aggregates
+}
+
+// NodeId returns the id of the node at path in the mount of the cluster. The id is derived from all three,
+// so it stays the same across crawls and nodes of different clusters never share one. Folders are identified
+// by a path ending in a slash, the same way Vault lists them, so a folder and a secret of the same name
+// never share an id either.
+func NodeId(cluster, mount, path string) string {
+	return uuid.NewSHA1(nodeNamespace, []byte(cluster+"\x00"+mount+"\x00"+path)).String()
+}
+
+// NewGraph returns the tree of the secrets of the mount in the cluster, with the folders leading to them.
+// The children of every folder are in the order Vault lists them in.
+func NewGraph(cluster, mount string, secrets []Secret) GraphEntry {
+	root := &graphNode{entry: GraphEntry{AbsolutePath: "/", Id: NodeId(cluster, mount, "/"), Name: "/", Folder: true}}
+	for _, secret := range secrets {
+		node := root
+		names := strings.Split(strings.Trim(secret.Path, "/"), "/")
+		for _, name := range names[:len(names)-1] {
+			node = node.folder(cluster, mount, name)
+		}
+		name := names[len(names)-1]
+		node.children = append(node.children, &graphNode{entry: GraphEntry{
+			AbsolutePath: node.folderPath() + name,
+			Id:           NodeId(cluster, mount, node.folderPath()+name),
+			Name:         name,
+		}})
+	}
+	return root.build()
+}
+
+// graphNode is a node of a tree being built by NewGraph, which finds the folders by name.
+type graphNode struct {
+	entry    GraphEntry
+	children []*graphNode
+	folders  map[string]*graphNode
+}
+
+// folderPath returns the path of the folder with a trailing slash.
+func (n *graphNode) folderPath() string {
+	return strings.TrimSuffix(n.entry.AbsolutePath, "/") + "/"
+}
+
+// folder returns the child folder with the name, which is created if there is none.
+func (n *graphNode) folder(cluster, mount, name string) *graphNode {
+	if folder, ok := n.folders[name]; ok {
+		return folder
+	}
+	folder := &graphNode{entry: GraphEntry{
+		AbsolutePath: n.folderPath() + name,
+		Id:           NodeId(cluster, mount, n.folderPath()+name+"/"),
+		Name:         name,
+		Folder:       true,
+	}}
+	if n.folders == nil {
+		n.folders = map[string]*graphNode{}
+	}
+	n.folders[name] = folder
+	n.children = append(n.children, folder)
+	return folder
+}
+
+func (n *graphNode) build() GraphEntry {
+	entry := n.entry
+	for _, child := range n.children {
+		entry.Children = append(entry.Children, child.build())
+	}
+	slices.SortFunc(entry.Children, func(a, b GraphEntry) int {
+		return strings.Compare(a.key(), b.key())
+	})
+	return entry
+}
+
+// FindById returns the entry with the given id.
+func (g GraphEntry) FindById(id string) (GraphEntry, bool) {
+	if g.Id == id {
+		return g, true
+	}
+	for _, child := range g.Children {
+		if entry, ok := child.FindById(id); ok {
+			return entry, true
+		}
+	}
+	return GraphEntry{}, false
+}
+
+// Folders returns the number of folders below the entry.
+func (g GraphEntry) Folders() int {
+	count := 0
+	for _, child := range g.Children {
+		if child.Folder {
+			count += 1 + child.Folders()
+		}
+	}
+	return count
+}
+
+// Find returns the entry at the absolute path, folders take precedence over secrets of the same name.
+func (g GraphEntry) Find(path string) (GraphEntry, bool) {
+	path = "/" + strings.Trim(path, "/")
+	if g.AbsolutePath == path {
+		return g, true
+	}
+	var found *GraphEntry
+	for i, child := range g.Children {
+		if child.AbsolutePath != path && !strings.HasPrefix(path, child.AbsolutePath+"/") {
+			continue
+		}
+		if !child.Folder {
+			if found == nil && child.AbsolutePath == path {
+				found = &g.Children[i]
+			}
+			continue
+		}
+		if entry, ok := child.Find(path); ok {
+			return entry, true
+		}
+	}
+	if found != nil {
+		return *found, true
+	}
+	return GraphEntry{}, false
+}
+
+// Put returns a copy of the tree with the secret, creating the folders leading to it.
+// If updated is set, it becomes the time the secret was last written. The copy shares the subtrees which did
+// not change with the tree, and its aggregates are not updated, Restrict computes them again.
+func (g GraphEntry) Put(secret Secret, updated *time.Time) GraphEntry {
+	return g.put(secret, "/", strings.Split(strings.Trim(secret.Path, "/"), "/"), updated)
+}
+
+func (g GraphEntry) put(secret Secret, folder string, names []string, updated *time.Time) GraphEntry {
+	g.Children = slices.Clone(g.Children)
+	name := names[0]
+	if len(names) == 1 {
+		for i, child := range g.Children {
+			if !child.Folder && child.Name == name {
+				if updated != nil {
+					g.Children[i].OldestUpdate = updated
+				}
+				return g
+			}
+		}
+		g.insert(GraphEntry{AbsolutePath: folder + name, Id: NodeId(secret.Cluster, secret.Mount, folder+name), Name: name,
+			Aggregates: Aggregates{OldestUpdate: updated}})
+		return g
+	}
+	for i, child := range g.Children {
+		if child.Folder && child.Name == name {
+			g.Children[i] = child.put(secret, folder+name+"/", names[1:], updated)
+			return g
+		}
+	}
+	created := GraphEntry{AbsolutePath: folder + name, Id: NodeId(secret.Cluster, secret.Mount, folder+name+"/"), Name: name, Folder: true}
+	g.insert(created.put(secret, folder+name+"/", names[1:], updated))
+	return g
+}
+
+// insert adds the child in the order Vault lists the keys of a folder in, where folders end in a slash.
+func (g *GraphEntry) insert(child GraphEntry) {
+	i := slices.IndexFunc(g.Children, func(sibling GraphEntry) bool {
+		return sibling.key() > child.key()
+	})
+	if i < 0 {
+		i = len(g.Children)
+	}
+	g.Children = slices.Insert(g.Children, i, child)
+}
+
+// key sorts the entry among its siblings the way Vault lists them, folders end in a slash.
+func (g GraphEntry) key() string {
+	if g.Folder {
+		return g.Name + "/"
+	}
+	return g.Name
 }
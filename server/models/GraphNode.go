@@ -0,0 +1,30 @@
+package models
+
+// GraphNode is a node of a subtree returned to clients, annotated with the aggregates of its whole subtree.
+type GraphNode struct {
+	Path        string      `json:"path"`
+	Id          string      `json:"id"`
+	Name        string      `json:"name"`
+	Folder      bool        `json:"folder"`
+	HasChildren bool        `json:"hasChildren"`
+	Children    []GraphNode `json:"children,omitempty"`
+	Aggregates
+}
+
+// Subtree converts the entry into a GraphNode including depth levels of children.
+func (g GraphEntry) Subtree(depth int) GraphNode {
+	node := GraphNode{
+		Path:        g.AbsolutePath,
+		Id:          g.Id,
+		Name:        g.Name,
+		Folder:      g.Folder,
+		HasChildren: len(g.Children) > 0,
+		Aggregates:  g.Aggregates,
+	}
+	if depth > 0 {
+		for _, child := range g.Children {
+			node.Children = append(node.Children, child.Subtree(depth-1))
+		}
+	}
+	return node
+}
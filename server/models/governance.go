@@ -0,0 +1,73 @@
+package models
+
+import (
+	"slices"
+	"strings"
+)
+
+// Governance are findings about the access to the secrets of a cluster, which point to access sprawl.
+type Governance struct {
+	// Unread counts the secrets of every mount no policy grants read access to.
+	Unread map[string]int
+	// Overexposed counts the secrets of every mount more policies than the threshold grant read access to.
+	Overexposed map[string]int
+	// Wildcard counts the policies with a rule whose first segment is a wildcard, e.g. * or +/data/*,
+	// which grants access to every mount.
+	Wildcard int
+	// Sudo counts the policies with a rule granting sudo.
+	Sudo int
+	// Unparsed counts the policies which could not be read or parsed, so the access they grant is unknown.
+	Unparsed int
+}
+
+// Assess returns the findings about the secrets and the policies. Secrets are overexposed
+// if more than maxReaders policies grant read access to them.
+func Assess(secrets []AnnotatedSecret, policies []Policy, unparsed int, maxReaders int) Governance {
+	governance := Governance{Unread: map[string]int{}, Overexposed: map[string]int{}, Unparsed: unparsed}
+	for _, secret := range secrets {
+		mount := secret.Path.Mount
+		readers := 0
+		for _, policy := range secret.Policies {
+			if slices.Contains(policy.CapabilitiesFor(secret.Path.Path), "read") {
+				readers++
+			}
+		}
+		// every mount is reported, so the gauges drop to zero once the findings are fixed
+		governance.Unread[mount] += 0
+		governance.Overexposed[mount] += 0
+		if readers == 0 {
+			governance.Unread[mount]++
+		}
+		if readers > maxReaders {
+			governance.Overexposed[mount]++
+		}
+	}
+	for _, policy := range policies {
+		if policy.hasRootWildcard() {
+			governance.Wildcard++
+		}
+		if policy.grants("sudo") {
+			governance.Sudo++
+		}
+	}
+	return governance
+}
+
+func (p Policy) hasRootWildcard() bool {
+	for _, rule := range p.Rules {
+		first, _, _ := strings.Cut(strings.TrimPrefix(rule.Path, "/"), "/")
+		if strings.ContainsAny(first, "*+") && !contains(rule.Capabilities, "deny") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) grants(capability string) bool {
+	for _, rule := range p.Rules {
+		if contains(rule.Capabilities, capability) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package models_test
+
+import (
+	"secretpaths/models"
+	"testing"
+)
+
+func TestAssess(t *testing.T) {
+	reader := models.NewPolicy("reader", []models.Rule{models.NewRule("/team-a/*", []string{"read", "list"})})
+	lister := models.NewPolicy("lister", []models.Rule{models.NewRule("/team-a/*", []string{"list"})})
+	everything := models.NewPolicy("everything", []models.Rule{models.NewRule("*", []string{"read", "list"})})
+	admin := models.NewPolicy("admin", []models.Rule{
+		models.NewRule("+/data/*", []string{"deny"}),
+		models.NewRule("sys/*", []string{"read", "sudo"}),
+	})
+	secrets := []models.AnnotatedSecret{
+		{Path: models.Secret{Path: "/team-a/db", Mount: "secret"}, Policies: []models.Policy{reader, lister, everything}},
+		{Path: models.Secret{Path: "/team-a/api", Mount: "secret"}, Policies: []models.Policy{lister}},
+		{Path: models.Secret{Path: "/team-b", Mount: "kv"}, Policies: []models.Policy{everything}},
+	}
+
+	governance := models.Assess(secrets, []models.Policy{reader, lister, everything, admin}, 2, 1)
+	if governance.Unread["secret"] != 1 || governance.Unread["kv"] != 0 {
+		t.Errorf("expected only /team-a/api to be unread, got %v", governance.Unread)
+	}
+	if governance.Overexposed["secret"] != 1 || governance.Overexposed["kv"] != 0 {
+		t.Errorf("expected only /team-a/db to be overexposed, got %v", governance.Overexposed)
+	}
+	if _, ok := governance.Unread["kv"]; !ok {
+		t.Error("expected mounts without findings to be reported")
+	}
+	if governance.Wildcard != 1 || governance.Sudo != 1 || governance.Unparsed != 2 {
+		t.Errorf("expected 1 wildcard, 1 sudo and 2 unparsed policies, got %+v", governance)
+	}
+}
@@ -0,0 +1,195 @@
+package models_test
+
+import (
+	"secretpaths/models"
+	"testing"
+	"time"
+)
+
+func graph() models.GraphEntry {
+	return models.GraphEntry{AbsolutePath: "/", Name: "/", Folder: true, Children: []models.GraphEntry{
+		{AbsolutePath: "/team-a", Name: "team-a", Folder: true, Children: []models.GraphEntry{
+			{AbsolutePath: "/team-a/db", Name: "db"},
+			{AbsolutePath: "/team-a/nested", Name: "nested", Folder: true, Children: []models.GraphEntry{
+				{AbsolutePath: "/team-a/nested/api", Name: "api"},
+			}},
+		}},
+		{AbsolutePath: "/team-b", Name: "team-b"},
+	}}
+}
+
+func TestGraphEntry_Find(t *testing.T) {
+	entry, ok := graph().Find("team-a/nested/")
+	if !ok {
+		t.Fatal("expected to find /team-a/nested")
+	}
+	if entry.AbsolutePath != "/team-a/nested" || !entry.Folder {
+		t.Errorf("expected folder /team-a/nested, got %+v", entry)
+	}
+	if _, ok := graph().Find("/team-c"); ok {
+		t.Error("expected: false, got: true, /team-c does not exist")
+	}
+}
+
+func annotated(path string, policies ...models.Policy) models.AnnotatedSecret {
+	return models.AnnotatedSecret{Path: models.Secret{Path: path, Mount: "secret"}, Policies: policies}
+}
+
+func aggregatedGraph() models.GraphEntry {
+	reader := models.NewPolicy("reader", []models.Rule{models.NewRule("/team-a/*", []string{"read"})})
+	writer := models.NewPolicy("writer", []models.Rule{models.NewRule("/team-a/db", []string{"read", "update"})})
+	admin := models.NewPolicy("admin", []models.Rule{models.NewRule("/*", []string{"read", "delete"})})
+	secrets := []models.AnnotatedSecret{
+		annotated("/team-a/db", reader, writer),
+		annotated("/team-a/nested/api", reader),
+		annotated("/team-b", admin),
+	}
+	updated := map[string]time.Time{
+		"/team-a/db":         time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"/team-a/nested/api": time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	root := graph()
+	root.Aggregate(secrets, updated)
+	return root
+}
+
+func TestGraphEntry_Folders(t *testing.T) {
+	if folders := graph().Folders(); folders != 2 {
+		t.Errorf("expected: %d, got: %d", 2, folders)
+	}
+}
+
+func TestGraphEntry_Aggregate(t *testing.T) {
+	root := aggregatedGraph()
+	if root.Secrets != 3 || root.Policies != 3 || root.MaxCapability != "delete" {
+		t.Errorf("unexpected aggregates of /: %+v", root.Aggregates)
+	}
+	teamA, _ := root.Find("/team-a")
+	if teamA.Secrets != 2 || teamA.Policies != 2 || teamA.MaxCapability != "update" {
+		t.Errorf("unexpected aggregates of /team-a: %+v", teamA.Aggregates)
+	}
+	if teamA.OldestUpdate == nil || teamA.OldestUpdate.Year() != 2023 {
+		t.Errorf("expected the oldest update of /team-a to be in 2023, got %v", teamA.OldestUpdate)
+	}
+	nested, _ := root.Find("/team-a/nested")
+	if nested.Policies != 1 || nested.MaxCapability != "read" {
+		t.Errorf("unexpected aggregates of /team-a/nested: %+v", nested.Aggregates)
+	}
+}
+
+func TestGraphEntry_Restrict(t *testing.T) {
+	original := aggregatedGraph()
+	reader := models.NewPolicy("reader", []models.Rule{models.NewRule("/team-a/*", []string{"read"})})
+	root := original.Restrict([]models.AnnotatedSecret{annotated("/team-a/nested/api", reader)})
+
+	if root.Secrets != 1 || root.Policies != 1 || root.MaxCapability != "read" {
+		t.Errorf("unexpected aggregates of /: %+v", root.Aggregates)
+	}
+	if root.OldestUpdate == nil || root.OldestUpdate.Year() != 2023 {
+		t.Errorf("expected the update time to be kept, got %v", root.OldestUpdate)
+	}
+	if _, ok := root.Find("/team-b"); ok {
+		t.Error("expected /team-b to be removed")
+	}
+	if _, ok := root.Find("/team-a/db"); ok {
+		t.Error("expected /team-a/db to be removed")
+	}
+	if original.Secrets != 3 || len(original.Children) != 2 {
+		t.Errorf("expected the original tree to be unchanged, got %+v", original.Aggregates)
+	}
+}
+
+func TestGraphEntry_Put(t *testing.T) {
+	original := graph()
+	written := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	root := original.Put(models.Secret{Path: "/team-a/nested/deeper/key", Mount: "secret", Cluster: "primary"}, &written)
+
+	entry, ok := root.Find("/team-a/nested/deeper/key")
+	if !ok || entry.Folder || entry.Id != models.NodeId("primary", "secret", "/team-a/nested/deeper/key") {
+		t.Fatalf("expected the secret to be added, got %+v", entry)
+	}
+	if entry.OldestUpdate == nil || !entry.OldestUpdate.Equal(written) {
+		t.Errorf("expected: %v, got: %v", written, entry.OldestUpdate)
+	}
+	deeper, ok := root.Find("/team-a/nested/deeper")
+	if !ok || !deeper.Folder || deeper.Id != models.NodeId("primary", "secret", "/team-a/nested/deeper/") {
+		t.Errorf("expected the folder to be created, got %+v", deeper)
+	}
+	if nested, _ := root.Find("/team-a/nested"); nested.Children[0].Name != "api" || nested.Children[1].Name != "deeper" {
+		t.Errorf("expected the children in the order Vault lists them, got %+v", nested.Children)
+	}
+	if _, ok := original.Find("/team-a/nested/deeper"); ok {
+		t.Error("expected the original tree to be unchanged")
+	}
+	again, _ := root.Put(models.Secret{Path: "/team-a/nested/deeper/key", Mount: "secret", Cluster: "primary"}, nil).Find("/team-a/nested/deeper")
+	if len(again.Children) != 1 || again.Children[0].OldestUpdate == nil {
+		t.Errorf("expected an existing secret to be kept as it is, got %+v", again.Children)
+	}
+}
+
+func TestGraphEntry_Subtree(t *testing.T) {
+	root := aggregatedGraph().Subtree(1)
+	if len(root.Children) != 2 {
+		t.Fatalf("expected: %d, got: %d", 2, len(root.Children))
+	}
+	teamA := root.Children[0]
+	if teamA.Secrets != 2 || teamA.Policies != 2 {
+		t.Errorf("expected 2 secrets and 2 policies, got %d and %d", teamA.Secrets, teamA.Policies)
+	}
+	if !teamA.HasChildren || len(teamA.Children) != 0 {
+		t.Errorf("expected children of /team-a to be cut off at depth 1, got %d", len(teamA.Children))
+	}
+}
+
+func TestNodeId(t *testing.T) {
+	if models.NodeId("primary", "secret", "/team-a/db") != models.NodeId("primary", "secret", "/team-a/db") {
+		t.Error("expected the id of a node to be the same for every crawl")
+	}
+	if models.NodeId("primary", "secret", "/team-a/db") == models.NodeId("primary", "kv", "/team-a/db") {
+		t.Error("expected nodes of different mounts to have different ids")
+	}
+	if models.NodeId("primary", "secret", "/team-a/db") == models.NodeId("secondary", "secret", "/team-a/db") {
+		t.Error("expected nodes of different clusters to have different ids")
+	}
+	if models.NodeId("primary", "secret", "/team-a/") == models.NodeId("primary", "secret", "/team-a") {
+		t.Error("expected a folder and a secret of the same name to have different ids")
+	}
+}
+
+func TestNewGraph(t *testing.T) {
+	var secrets []models.Secret
+	for _, path := range []string{"/team-b", "/team-a/nested/api", "/team-a/db", "/team-a/nested"} {
+		secrets = append(secrets, models.Secret{Path: path, Mount: "secret", Cluster: "primary"})
+	}
+	root := models.NewGraph("primary", "secret", secrets)
+	if root.Id != models.NodeId("primary", "secret", "/") || len(root.Children) != 2 {
+		t.Fatalf("expected a root with two children, got %+v", root)
+	}
+	teamA := root.Children[0]
+	if teamA.Name != "team-a" || !teamA.Folder || teamA.Id != models.NodeId("primary", "secret", "/team-a/") {
+		t.Fatalf("expected the folder /team-a first, got %+v", teamA)
+	}
+	var listed []string
+	for _, child := range teamA.Children {
+		listed = append(listed, child.AbsolutePath)
+	}
+	// Vault lists a secret before a folder of the same name
+	if len(listed) != 3 || listed[0] != "/team-a/db" || listed[1] != "/team-a/nested" || teamA.Children[1].Folder || !teamA.Children[2].Folder {
+		t.Errorf("expected the children in the order Vault lists them, got %v", listed)
+	}
+	if api, ok := root.Find("/team-a/nested/api"); !ok || api.Id != models.NodeId("primary", "secret", "/team-a/nested/api") {
+		t.Errorf("expected /team-a/nested/api, got %+v", api)
+	}
+	if empty := models.NewGraph("primary", "secret", nil); !empty.Folder || len(empty.Children) != 0 {
+		t.Errorf("expected an empty root, got %+v", empty)
+	}
+}
+
+func TestGraphEntry_FindById(t *testing.T) {
+	root := graph()
+	root.Children[0].Children[1].Id = models.NodeId("primary", "secret", "/team-a/nested/")
+	entry, ok := root.FindById(models.NodeId("primary", "secret", "/team-a/nested/"))
+	if !ok || entry.AbsolutePath != "/team-a/nested" {
+		t.Errorf("expected to find /team-a/nested, got %+v", entry)
+	}
+}
@@ -59,6 +59,27 @@ func (p Policy) HasAccessTo(path string) bool {
 	return false
 }
 
+// MatchingRule returns the rule granting access to path, following the same precedence as HasAccessTo.
+func (p Policy) MatchingRule(path string) (Rule, bool) {
+	for _, rule := range p.Rules {
+		if rule.HasAccessTo(path) {
+			return rule, true
+		}
+		if rule.SpecificallyDeniesAccessTo(path) {
+			return Rule{}, false
+		}
+	}
+	return Rule{}, false
+}
+
+// CapabilitiesFor returns the capabilities the matching rule grants on path. It returns nil if access is denied.
+func (p Policy) CapabilitiesFor(path string) []string {
+	if rule, ok := p.MatchingRule(path); ok {
+		return rule.Capabilities
+	}
+	return nil
+}
+
 func (p Policy) containsDenyCapability() bool {
 	for _, rule := range p.Rules {
 		if contains(rule.Capabilities, "deny") {
@@ -68,6 +89,25 @@ func (p Policy) containsDenyCapability() bool {
 	return false
 }
 
+// capabilityOrder ranks the capabilities granting access from least to most powerful.
+var capabilityOrder = []string{"list", "read", "create", "patch", "update", "delete", "sudo"}
+
+// MaxCapability returns the most powerful of the given capabilities, or an empty string if none grants access.
+func MaxCapability(capabilities ...string) string {
+	max := -1
+	for _, capability := range capabilities {
+		for rank, known := range capabilityOrder {
+			if known == capability && rank > max {
+				max = rank
+			}
+		}
+	}
+	if max == -1 {
+		return ""
+	}
+	return capabilityOrder[max]
+}
+
 func contains(slice []string, needle string) bool {
 	for _, element := range slice {
 		if element == needle {
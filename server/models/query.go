@@ -0,0 +1,171 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	SortByPath     = "path"
+	SortByPolicies = "policies"
+
+	MaxQueryLimit = 1000
+)
+
+// SecretQuery filters, sorts and paginates a list of annotated secrets.
+// Empty fields do not restrict the result.
+type SecretQuery struct {
+	Prefix     string
+	Glob       string
+	Regex      *regexp.Regexp
+	Policy     string
+	Capability string
+	Mount      string
+	Cluster    string
+	Sort       string
+	Descending bool
+	Cursor     string
+	Limit      int
+}
+
+// Page is a single page of query results, Next is empty on the last page.
+type Page struct {
+	Items []AnnotatedSecret
+	Total int
+	Next  string
+}
+
+// NeedsPolicies returns true if the query can only be answered with policy information.
+func (q SecretQuery) NeedsPolicies() bool {
+	return q.Policy != "" || q.Capability != "" || q.Sort == SortByPolicies
+}
+
+func (q SecretQuery) Matches(secret AnnotatedSecret) bool {
+	p := secret.Path.Path
+	if q.Mount != "" && secret.Path.Mount != q.Mount {
+		return false
+	}
+	if q.Cluster != "" && secret.Path.Cluster != q.Cluster {
+		return false
+	}
+	if q.Prefix != "" && !strings.HasPrefix(p, q.Prefix) {
+		return false
+	}
+	if q.Glob != "" {
+		if matched, err := path.Match(q.Glob, p); err != nil || !matched {
+			return false
+		}
+	}
+	if q.Regex != nil && !q.Regex.MatchString(p) {
+		return false
+	}
+	if q.Policy == "" && q.Capability == "" {
+		return true
+	}
+	for _, policy := range secret.Policies {
+		if q.Policy != "" && policy.Name != q.Policy {
+			continue
+		}
+		if q.Capability == "" || contains(policy.CapabilitiesFor(p), q.Capability) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the page of secrets selected by the query. The input slice is not modified.
+func (q SecretQuery) Apply(secrets []AnnotatedSecret) (Page, error) {
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return Page{}, err
+	}
+	matched := []AnnotatedSecret{}
+	for _, secret := range secrets {
+		if q.Matches(secret) {
+			matched = append(matched, secret)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return q.before(keyOf(matched[i]), keyOf(matched[j]))
+	})
+
+	page := Page{Total: len(matched)}
+	start := 0
+	if after != nil {
+		// continues after the last item of the previous page, even if secrets were added or removed since
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.before(*after, keyOf(matched[i]))
+		})
+	}
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.Next = encodeCursor(keyOf(matched[end-1]))
+	}
+	page.Items = matched[start:end]
+	return page, nil
+}
+
+// sortKey orders the secrets of a query, it is unique for every secret.
+type sortKey struct {
+	Policies int    `json:"policies"`
+	Path     string `json:"path"`
+	Mount    string `json:"mount"`
+	Cluster  string `json:"cluster"`
+}
+
+func keyOf(secret AnnotatedSecret) sortKey {
+	return sortKey{Policies: len(secret.Policies), Path: secret.Path.Path, Mount: secret.Path.Mount, Cluster: secret.Path.Cluster}
+}
+
+// before returns true if the secret with the key a comes before the one with the key b.
+func (q SecretQuery) before(a, b sortKey) bool {
+	if q.Descending {
+		a, b = b, a
+	}
+	if q.Sort == SortByPolicies && a.Policies != b.Policies {
+		return a.Policies < b.Policies
+	}
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	if a.Mount != b.Mount {
+		return a.Mount < b.Mount
+	}
+	return a.Cluster < b.Cluster
+}
+
+// ValidateSort checks that the sort field is one the query knows about.
+func ValidateSort(field string) error {
+	switch field {
+	case "", SortByPath, SortByPolicies:
+		return nil
+	}
+	return fmt.Errorf("unknown sort field %q", field)
+}
+
+func encodeCursor(key sortKey) string {
+	raw, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor returns the key of the last item of the previous page, nil for the first page.
+func decodeCursor(cursor string) (*sortKey, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var key sortKey
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &key, nil
+}
@@ -0,0 +1,140 @@
+package models_test
+
+import (
+	"regexp"
+	"secretpaths/models"
+	"testing"
+)
+
+func querySecrets() []models.AnnotatedSecret {
+	reader := models.NewPolicy("reader", []models.Rule{models.NewRule("/team-a/*", []string{"read"})})
+	writer := models.NewPolicy("writer", []models.Rule{models.NewRule("/team-a/db", []string{"create", "update"})})
+	return []models.AnnotatedSecret{
+		{Path: models.Secret{Path: "/team-b/api", Mount: "secret"}},
+		{Path: models.Secret{Path: "/team-a/db", Mount: "secret"}, Policies: []models.Policy{reader, writer}},
+		{Path: models.Secret{Path: "/team-a/cache", Mount: "secret"}, Policies: []models.Policy{reader}},
+		{Path: models.Secret{Path: "/team-a/db", Mount: "kv", Cluster: "eu"}},
+	}
+}
+
+func paths(page models.Page) []string {
+	var result []string
+	for _, secret := range page.Items {
+		path := secret.Path.Mount + ":" + secret.Path.Path
+		if secret.Path.Cluster != "" {
+			path = secret.Path.Cluster + "/" + path
+		}
+		result = append(result, path)
+	}
+	return result
+}
+
+func expectPaths(t *testing.T, page models.Page, expected ...string) {
+	t.Helper()
+	got := paths(page)
+	if len(got) != len(expected) {
+		t.Fatalf("expected: %v, got: %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected: %v, got: %v", expected, got)
+		}
+	}
+}
+
+func TestSecretQuery_Filters(t *testing.T) {
+	secrets := querySecrets()
+	tests := []struct {
+		name     string
+		query    models.SecretQuery
+		expected []string
+	}{
+		{"prefix", models.SecretQuery{Prefix: "/team-a/"}, []string{"secret:/team-a/cache", "eu/kv:/team-a/db", "secret:/team-a/db"}},
+		{"glob", models.SecretQuery{Glob: "/*/db"}, []string{"eu/kv:/team-a/db", "secret:/team-a/db"}},
+		{"regex", models.SecretQuery{Regex: regexp.MustCompile("api$")}, []string{"secret:/team-b/api"}},
+		{"mount", models.SecretQuery{Mount: "kv"}, []string{"eu/kv:/team-a/db"}},
+		{"cluster", models.SecretQuery{Cluster: "eu"}, []string{"eu/kv:/team-a/db"}},
+		{"policy", models.SecretQuery{Policy: "reader"}, []string{"secret:/team-a/cache", "secret:/team-a/db"}},
+		{"capability", models.SecretQuery{Capability: "update"}, []string{"secret:/team-a/db"}},
+		{"policy and capability", models.SecretQuery{Policy: "reader", Capability: "update"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := test.query.Apply(secrets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectPaths(t, page, test.expected...)
+		})
+	}
+}
+
+func TestSecretQuery_SortByPolicies(t *testing.T) {
+	page, err := models.SecretQuery{Sort: models.SortByPolicies, Descending: true}.Apply(querySecrets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(page)[0]; got != "secret:/team-a/db" {
+		t.Errorf("expected: %s, got: %s", "secret:/team-a/db", got)
+	}
+}
+
+func TestSecretQuery_Pagination(t *testing.T) {
+	secrets := querySecrets()
+	query := models.SecretQuery{Limit: 3}
+	first, err := query.Apply(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Total != 4 || len(first.Items) != 3 || first.Next == "" {
+		t.Fatalf("unexpected first page: total %d, items %d, next %q", first.Total, len(first.Items), first.Next)
+	}
+	query.Cursor = first.Next
+	second, err := query.Apply(secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, second, "secret:/team-b/api")
+	if second.Next != "" {
+		t.Errorf("expected no further page, got cursor %q", second.Next)
+	}
+
+	// the secrets of the first page are deleted, the next page still continues after the last one shown
+	third, err := query.Apply(secrets[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPaths(t, third, "secret:/team-b/api")
+}
+
+func TestSecretQuery_PaginationByPolicies(t *testing.T) {
+	query := models.SecretQuery{Sort: models.SortByPolicies, Descending: true, Limit: 1}
+	var visited []string
+	for i := 0; i < 5; i++ {
+		page, err := query.Apply(querySecrets())
+		if err != nil {
+			t.Fatal(err)
+		}
+		visited = append(visited, paths(page)...)
+		if page.Next == "" {
+			break
+		}
+		query.Cursor = page.Next
+	}
+	expected := []string{"secret:/team-a/db", "secret:/team-a/cache", "secret:/team-b/api", "eu/kv:/team-a/db"}
+	if len(visited) != len(expected) {
+		t.Fatalf("expected: %v, got: %v", expected, visited)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Fatalf("expected: %v, got: %v", expected, visited)
+		}
+	}
+}
+
+func TestSecretQuery_InvalidCursor(t *testing.T) {
+	_, err := models.SecretQuery{Cursor: "not a cursor"}.Apply(querySecrets())
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+}
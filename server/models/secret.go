@@ -1,5 +1,7 @@
 package models
 
 type Secret struct {
-	Path string `json:"path"`
+	Path    string `json:"path"`
+	Mount   string `json:"mount"`
+	Cluster string `json:"cluster,omitempty"`
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds the checks of a cluster, probes of Kubernetes give up after a few seconds.
+const readinessTimeout = 3 * time.Second
+
+// clusterReadiness is the state of a cluster reported by /v1/readyz.
+type clusterReadiness struct {
+	Ready bool `json:"ready"`
+	// Vault is ok, or why Vault is unhealthy or rejects the token.
+	Vault     string     `json:"vault"`
+	CrawledAt *time.Time `json:"crawledAt"`
+}
+
+// livez reports that the process is serving requests, it does not depend on Vault,
+// so the pod is not restarted while Vault is down.
+func livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz checks every cluster in parallel: Vault must be healthy, accept the token and the cluster must
+// have been crawled successfully at least once. It responds with 503 unless at least one cluster is ready,
+// clusters which are not ready are left out of the responses like clusters that are down.
+func readyz(c *gin.Context) {
+	clusters := c.MustGet("clusters").([]*cluster)
+	states := make([]clusterReadiness, len(clusters))
+	var wg sync.WaitGroup
+	for i, target := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			states[i] = checkCluster(c.Request.Context(), target)
+		}()
+	}
+	wg.Wait()
+
+	status, response := http.StatusServiceUnavailable, gin.H{"status": "not ready"}
+	byName := gin.H{}
+	for i, target := range clusters {
+		if states[i].Ready {
+			status, response["status"] = http.StatusOK, "ready"
+		}
+		byName[target.name] = states[i]
+	}
+	response["clusters"] = byName
+	c.JSON(status, response)
+}
+
+func checkCluster(ctx context.Context, target *cluster) clusterReadiness {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	state := clusterReadiness{Vault: "ok"}
+	if inventory := target.inventory.Load(); inventory != nil {
+		state.CrawledAt = &inventory.crawledAt
+	}
+	if err := target.manager.Check(ctx); err != nil {
+		state.Vault = err.Error()
+	}
+	state.Ready = state.Vault == "ok" && state.CrawledAt != nil
+	return state
+}
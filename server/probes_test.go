@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+type readiness struct {
+	Status   string                      `json:"status"`
+	Clusters map[string]clusterReadiness `json:"clusters"`
+}
+
+func TestLivez(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.change(func(v *fakeVault) { v.uninitialized = true })
+	server := newTestServer(t, []*cluster{newTestCluster("primary", vault)}, nil)
+
+	if response := call(t, http.MethodGet, server.URL+"/v1/livez", nil); response.StatusCode != http.StatusOK {
+		t.Errorf("expected: %v, got: %v", http.StatusOK, response.StatusCode)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("not crawled yet", func(t *testing.T) {
+		server := newTestServer(t, []*cluster{newTestCluster("primary", newFakeVault(t))}, nil)
+
+		response := call(t, http.MethodGet, server.URL+"/v1/readyz", nil)
+		if response.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected: %v, got: %v", http.StatusServiceUnavailable, response.StatusCode)
+		}
+	})
+	t.Run("crawled", func(t *testing.T) {
+		primary := newTestCluster("primary", newFakeVault(t))
+		crawl(t, primary)
+		server := newTestServer(t, []*cluster{primary}, nil)
+
+		var result readiness
+		response := call(t, http.MethodGet, server.URL+"/v1/readyz", &result)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, response.StatusCode)
+		}
+		if state := result.Clusters["primary"]; result.Status != "ready" || !state.Ready || state.CrawledAt == nil {
+			t.Errorf("expected a ready cluster, got %+v", result)
+		}
+	})
+	t.Run("unhealthy Vault", func(t *testing.T) {
+		vault := newFakeVault(t)
+		primary := newTestCluster("primary", vault)
+		crawl(t, primary)
+		vault.change(func(v *fakeVault) { v.uninitialized = true })
+		server := newTestServer(t, []*cluster{primary}, nil)
+
+		response := call(t, http.MethodGet, server.URL+"/v1/readyz", nil)
+		if response.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected: %v, got: %v", http.StatusServiceUnavailable, response.StatusCode)
+		}
+	})
+	t.Run("one of the clusters is ready", func(t *testing.T) {
+		vault := newFakeVault(t)
+		primary, secondary := newTestCluster("primary", newFakeVault(t)), newTestCluster("secondary", vault)
+		tertiary := newTestCluster("tertiary", newFakeVault(t))
+		crawl(t, primary)
+		crawl(t, secondary)
+		vault.change(func(v *fakeVault) { v.uninitialized = true })
+		server := newTestServer(t, []*cluster{primary, secondary, tertiary}, nil)
+
+		var result readiness
+		response := call(t, http.MethodGet, server.URL+"/v1/readyz", &result)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, response.StatusCode)
+		}
+		if !result.Clusters["primary"].Ready {
+			t.Errorf("expected the primary cluster to be ready, got %+v", result.Clusters["primary"])
+		}
+		if state := result.Clusters["secondary"]; state.Ready || state.Vault == "ok" || state.CrawledAt == nil {
+			t.Errorf("expected the unhealthy Vault of the secondary cluster, got %+v", state)
+		}
+		if state := result.Clusters["tertiary"]; state.Ready || state.Vault != "ok" || state.CrawledAt != nil {
+			t.Errorf("expected the tertiary cluster not to be crawled yet, got %+v", state)
+		}
+	})
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"secretpaths/apiauth"
+	"secretpaths/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval limits how often callers can start a crawl, a full crawl puts load on every cluster.
+const minRefreshInterval = time.Minute
+
+// keptJobs is the number of finished jobs which can still be polled.
+const keptJobs = 20
+
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobPartial   = "partial"
+	jobFailed    = "failed"
+)
+
+// refreshJob is one crawl of every cluster, polled via /v1/refresh/:id.
+type refreshJob struct {
+	Id string `json:"id"`
+	// Status is running until every cluster is crawled, then succeeded, partial if some clusters failed, or failed.
+	Status string `json:"status"`
+	// Trigger is schedule, or the name of the caller who requested the crawl.
+	Trigger    string            `json:"trigger"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt *time.Time        `json:"finishedAt,omitempty"`
+	Clusters   []clusterProgress `json:"clusters"`
+}
+
+type clusterProgress struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Step is the stage of the crawl the cluster is in, e.g. secrets.
+	Step    string `json:"step,omitempty"`
+	Secrets int    `json:"secrets"`
+	Error   string `json:"error,omitempty"`
+}
+
+// refresher runs crawls on schedule and on request, a cluster is only crawled by one job at a time.
+type refresher struct {
+	clusters []*cluster
+	// ctx is done once the server shuts down, which cancels the running crawl.
+	ctx    context.Context
+	crawls sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs []*refreshJob
+	// crawling maps the name of every cluster which is being crawled to its job.
+	crawling map[string]*refreshJob
+	// requested maps the name of every cluster to when a caller last started a crawl of it.
+	requested map[string]time.Time
+	stopped   bool
+}
+
+func newRefresher(ctx context.Context, clusters []*cluster) *refresher {
+	return &refresher{ctx: ctx, clusters: clusters, crawling: map[string]*refreshJob{}, requested: map[string]time.Time{}}
+}
+
+// schedule adds a job to the scheduler for every cluster, which crawls it on its own schedule.
+func (r *refresher) schedule(scheduler gocron.Scheduler) error {
+	for _, target := range r.clusters {
+		definition := gocron.DurationJob(target.schedule.Interval)
+		if target.schedule.Cron != "" {
+			definition = gocron.CronJob(target.schedule.Cron, false)
+		}
+		if _, err := scheduler.NewJob(definition, gocron.NewTask(r.scheduled, target), gocron.WithName("crawl "+target.name)); err != nil {
+			return fmt.Errorf("could not schedule the crawls of %s: %w", target.name, err)
+		}
+		slog.Info("scheduled the crawls", "cluster", target.name, "interval", target.schedule.Interval,
+			"cron", target.schedule.Cron, "jitter", target.schedule.Jitter, "windows", target.schedule.Windows)
+	}
+	return nil
+}
+
+// start begins a crawl of the clusters and returns its job. Clusters which are already being crawled are left out,
+// if all of them are, the job crawling the first one is returned instead.
+// Once the refresher is stopped, no crawl is started and the job is empty.
+func (r *refresher) start(trigger string, targets []*cluster) (refreshJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.startLocked(trigger, targets)
+}
+
+// startLocked is start for callers holding mu.
+func (r *refresher) startLocked(trigger string, targets []*cluster) (refreshJob, bool) {
+	if r.stopped || r.ctx.Err() != nil {
+		return refreshJob{}, false
+	}
+	if running := r.crawlingAll(targets); running != nil {
+		return running.copy(), false
+	}
+	job := &refreshJob{Id: uuid.NewString(), Status: jobRunning, Trigger: trigger, StartedAt: time.Now()}
+	var idle []*cluster
+	for _, target := range targets {
+		if r.crawling[target.name] == nil {
+			idle = append(idle, target)
+			job.Clusters = append(job.Clusters, clusterProgress{Name: target.name, Status: jobPending})
+			r.crawling[target.name] = job
+		}
+	}
+	r.jobs = append(r.jobs, job)
+	if len(r.jobs) > keptJobs {
+		r.jobs = r.jobs[len(r.jobs)-keptJobs:]
+	}
+	r.crawls.Add(1)
+	go func() {
+		defer r.crawls.Done()
+		r.run(job, idle)
+	}()
+	return job.copy(), true
+}
+
+// crawlingAll returns the job crawling the first of the clusters if every one of them is being crawled.
+func (r *refresher) crawlingAll(targets []*cluster) *refreshJob {
+	for _, target := range targets {
+		if r.crawling[target.name] == nil {
+			return nil
+		}
+	}
+	return r.crawling[targets[0].name]
+}
+
+// run crawls the clusters of the job in parallel, a cluster which fails keeps its previous inventory.
+func (r *refresher) run(job *refreshJob, targets []*cluster) {
+	ctx := logging.With(r.ctx, "refresh", job.Id)
+	logging.FromContext(ctx).Info("crawling the clusters", "clusters", len(targets), "trigger", job.Trigger)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.update(job, i, func(progress *clusterProgress) {
+				progress.Status = jobRunning
+			})
+			ctx := logging.With(ctx, "cluster", target.name)
+			secrets, err := updateCluster(ctx, target, func(step string) {
+				r.update(job, i, func(progress *clusterProgress) {
+					progress.Step = step
+				})
+			})
+			r.update(job, i, func(progress *clusterProgress) {
+				progress.Step, progress.Secrets = "", secrets
+				progress.Status = jobSucceeded
+				if err != nil {
+					logging.FromContext(ctx).Error("could not crawl the cluster", logging.Error(err))
+					progress.Status, progress.Error = jobFailed, err.Error()
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failed := 0
+	for _, progress := range job.Clusters {
+		if progress.Status == jobFailed {
+			failed++
+		}
+	}
+	switch failed {
+	case 0:
+		job.Status = jobSucceeded
+	case len(job.Clusters):
+		job.Status = jobFailed
+	default:
+		job.Status = jobPartial
+	}
+	finished := time.Now()
+	job.FinishedAt = &finished
+	for _, target := range targets {
+		delete(r.crawling, target.name)
+	}
+	logging.FromContext(ctx).Info("crawled the clusters", "status", job.Status, "duration", finished.Sub(job.StartedAt).Round(time.Millisecond))
+}
+
+// stop prevents new crawls and waits until the running crawl returned, it is cancelled together with
+// the context of the refresher. It gives up once ctx is done.
+func (r *refresher) stop(ctx context.Context) error {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		r.crawls.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *refresher) update(job *refreshJob, cluster int, change func(*clusterProgress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(&job.Clusters[cluster])
+}
+
+// request starts a crawl of the clusters for a caller, or returns the running one. It returns how long to wait
+// if a caller started a crawl of one of the clusters too recently.
+func (r *refresher) request(trigger string, targets []*cluster) (refreshJob, bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if running := r.crawlingAll(targets); running != nil {
+		return running.copy(), false, 0
+	}
+	var wait time.Duration
+	for _, target := range targets {
+		wait = max(wait, minRefreshInterval-time.Since(r.requested[target.name]))
+	}
+	if wait > 0 {
+		return refreshJob{}, false, wait
+	}
+	job, started := r.startLocked(trigger, targets)
+	if started {
+		for _, progress := range job.Clusters {
+			r.requested[progress.Name] = job.StartedAt
+		}
+	}
+	return job, started, 0
+}
+
+func (r *refresher) job(id string) (refreshJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, job := range r.jobs {
+		if job.Id == id {
+			return job.copy(), true
+		}
+	}
+	return refreshJob{}, false
+}
+
+// copy returns a snapshot of the job, which can be rendered while the crawl goes on.
+func (j *refreshJob) copy() refreshJob {
+	snapshot := *j
+	snapshot.Clusters = append([]clusterProgress(nil), j.Clusters...)
+	return snapshot
+}
+
+// scheduled is run by the scheduler for a cluster. It waits for a random part of the jitter and skips the crawl
+// if it is outside the windows of the cluster or the previous crawl is still running.
+func (r *refresher) scheduled(target *cluster) {
+	ctx := logging.With(r.ctx, "cluster", target.name)
+	if jitter := target.schedule.Jitter; jitter > 0 {
+		select {
+		case <-time.After(rand.N(jitter)):
+		case <-ctx.Done():
+			return
+		}
+	}
+	if !target.schedule.Allows(time.Now()) {
+		logging.FromContext(ctx).Debug("skipping the scheduled crawl, it is outside the windows", "windows", target.schedule.Windows)
+		return
+	}
+	if job, started := r.start("schedule", []*cluster{target}); !started && job.Id != "" {
+		logging.FromContext(ctx).Info("skipping the scheduled crawl, the previous one is still running")
+	}
+}
+
+// postRefresh starts a crawl and responds with its job, 202 if it was started and 200 if it was already running.
+func (r *refresher) postRefresh(c *gin.Context) {
+	principal := c.MustGet("principal").(apiauth.Principal)
+	targets, ok := selectClusters(c)
+	if !ok {
+		return
+	}
+	job, started, wait := r.request(principal.Name, targets)
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("a crawl was requested less than %v ago", minRefreshInterval)})
+		return
+	}
+	if job.Id == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the server is shutting down"})
+		return
+	}
+	c.Header("Location", "/v1/refresh/"+job.Id)
+	status := http.StatusAccepted
+	if !started {
+		status = http.StatusOK
+	}
+	c.JSON(status, job)
+}
+
+func (r *refresher) getRefresh(c *gin.Context) {
+	job, ok := r.job(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"secretpaths/config"
+	"testing"
+	"time"
+)
+
+func TestUpdateCluster_ListsOnce(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.change(func(vault *fakeVault) {
+		vault.secrets = append(vault.secrets, "/team-a/api")
+	})
+	target := newTestCluster("primary", vault)
+	crawl(t, target)
+
+	for _, folder := range []string{"/", "/team-a/", "/team-a/api/", "/team-b/"} {
+		if listed := vault.count("/v1/secret/metadata/" + folder + "/"); listed != 1 {
+			t.Errorf("expected %s to be listed once, got %d", folder, listed)
+		}
+	}
+	inventory := target.inventory.Load()
+	if len(inventory.paths) != 4 || len(inventory.secrets) != 4 {
+		t.Fatalf("expected 4 secrets, got %d paths and %d secrets", len(inventory.paths), len(inventory.secrets))
+	}
+	if api, ok := inventory.graph.Find("/team-a/api"); !ok || !api.Folder || api.Secrets != 1 {
+		t.Errorf("expected the folder /team-a/api with one secret, got %+v", api)
+	}
+	if inventory.graph.Secrets != 4 || inventory.compressed.Secrets != 4 {
+		t.Errorf("expected the graph to count 4 secrets, got %d", inventory.graph.Secrets)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	vault := newFakeVault(t)
+	server := newTestServer(t, []*cluster{newTestCluster("primary", vault)}, func(settings *config.Config) {
+		settings.API.AnonymousRole = "admin"
+	})
+
+	var job refreshJob
+	response := call(t, http.MethodPost, server.URL+"/v1/refresh", &job)
+	if response.StatusCode != http.StatusAccepted || job.Id == "" {
+		t.Fatalf("expected a crawl to be started, got %d", response.StatusCode)
+	}
+	if location := response.Header.Get("Location"); location != "/v1/refresh/"+job.Id {
+		t.Errorf("expected the location of the job, got %q", location)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == jobRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		call(t, http.MethodGet, server.URL+"/v1/refresh/"+job.Id, &job)
+	}
+	if job.Status != jobSucceeded || job.FinishedAt == nil || job.Trigger != "anonymous" {
+		t.Fatalf("expected the job to succeed, got %+v", job)
+	}
+	if len(job.Clusters) != 1 || job.Clusters[0].Name != "primary" || job.Clusters[0].Secrets != 3 {
+		t.Errorf("expected the progress of primary with 3 secrets, got %+v", job.Clusters)
+	}
+
+	response = call(t, http.MethodPost, server.URL+"/v1/refresh", nil)
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected: %d, got: %d", http.StatusTooManyRequests, response.StatusCode)
+	}
+	if retry := response.Header.Get("Retry-After"); retry == "" || retry == "0" {
+		t.Errorf("expected to be told when to retry, got %q", retry)
+	}
+	if response := call(t, http.MethodGet, server.URL+"/v1/refresh/unknown", nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("expected: %d, got: %d", http.StatusNotFound, response.StatusCode)
+	}
+}
+
+func TestRefresh_PerCluster(t *testing.T) {
+	primary, secondary := newTestCluster("primary", newFakeVault(t)), newTestCluster("secondary", newFakeVault(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	refresh := newRefresher(ctx, []*cluster{primary, secondary})
+	defer func() {
+		_ = refresh.stop(context.Background())
+	}()
+
+	job, started, _ := refresh.request("alice", []*cluster{primary})
+	if !started {
+		t.Fatal("expected a crawl of primary to be started")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == jobRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		job, _ = refresh.job(job.Id)
+	}
+	if _, started, wait := refresh.request("alice", []*cluster{secondary}); !started || wait != 0 {
+		t.Errorf("expected a crawl of secondary to be started right after primary, got %v", wait)
+	}
+	if _, started, wait := refresh.request("alice", []*cluster{primary}); started || wait <= 0 {
+		t.Errorf("expected primary to be crawled at most once per minute, got %v", wait)
+	}
+}
+
+func TestRefresh_NotStarted(t *testing.T) {
+	target := newTestCluster("primary", newFakeVault(t))
+	refresh := newRefresher(context.Background(), []*cluster{target})
+	if err := refresh.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, started, wait := refresh.request("alice", []*cluster{target}); started || job.Id != "" || wait != 0 {
+		t.Fatalf("expected no crawl to be started after the refresher stopped, got %+v", job)
+	}
+	if len(refresh.requested) != 0 {
+		t.Errorf("expected a crawl which was not started not to count, got %v", refresh.requested)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+	"regexp"
+	"secretpaths/models"
+	"strconv"
+)
+
+// parseSecretQuery reads the filter, sort and pagination parameters shared by
+// /v1/paths and /v1/annotatedSecrets.
+func parseSecretQuery(c *gin.Context) (models.SecretQuery, error) {
+	query := models.SecretQuery{
+		Prefix:     c.Query("prefix"),
+		Glob:       c.Query("glob"),
+		Policy:     c.Query("policy"),
+		Capability: c.Query("capability"),
+		Mount:      c.Query("mount"),
+		Cluster:    c.Query("cluster"),
+		Sort:       c.Query("sort"),
+		Cursor:     c.Query("cursor"),
+	}
+	if err := models.ValidateSort(query.Sort); err != nil {
+		return query, err
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+	if query.Glob != "" {
+		if _, err := path.Match(query.Glob, ""); err != nil {
+			return query, fmt.Errorf("invalid glob: %v", err)
+		}
+	}
+	if expression := c.Query("regex"); expression != "" {
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			return query, fmt.Errorf("invalid regex: %v", err)
+		}
+		query.Regex = regex
+	}
+	if limit := c.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 {
+			return query, fmt.Errorf("limit must be a positive number")
+		}
+		query.Limit = min(l, models.MaxQueryLimit)
+	}
+	return query, nil
+}
+
+// writePage sets the pagination headers and renders the items of the page.
+func writePage[T any](c *gin.Context, page models.Page, items []T) {
+	c.Header("X-Total-Count", strconv.Itoa(page.Total))
+	if page.Next != "" {
+		c.Header("X-Next-Cursor", page.Next)
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func badRequest(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
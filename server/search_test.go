@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseSecretQuery_Invalid(t *testing.T) {
+	target := newTestCluster("primary", newFakeVault(t))
+	crawl(t, target)
+	server := newTestServer(t, []*cluster{target}, nil)
+	tests := map[string]string{
+		"glob":  "glob=" + url.QueryEscape("/team-a/[db"),
+		"regex": "regex=" + url.QueryEscape("team-(a"),
+		"order": "order=sideways",
+		"limit": "limit=0",
+		"sort":  "sort=size",
+	}
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			if response := call(t, http.MethodGet, server.URL+"/v1/paths?"+query, nil); response.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected: %d, got: %d", http.StatusBadRequest, response.StatusCode)
+			}
+		})
+	}
+}
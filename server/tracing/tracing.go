@@ -0,0 +1,160 @@
+// Package tracing exports OpenTelemetry traces of the API, the crawls and the requests to Vault.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"secretpaths/logging"
+	"secretpaths/metrics"
+	"strings"
+)
+
+const serviceName = "secretpaths"
+
+var tracer = otel.Tracer("secretpaths")
+
+// Setup installs the exporter, none keeps the default provider, which drops every span.
+// The returned function flushes the spans which were not exported yet.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		spanExporter, err = stdouttrace.New()
+	default:
+		err = fmt.Errorf("unknown exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	described, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(Redacting(spanExporter)),
+		sdktrace.WithResource(described),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// urlKeys are the attributes of the spans of requests to Vault which hold the URL of the request,
+// the older and the current semantic conventions name it differently.
+var urlKeys = []attribute.Key{"http.url", "url.full"}
+
+// Redacting returns an exporter which redacts the URLs of the requests to Vault in the spans before they are
+// exported, the same way the paths of secrets are logged. The URLs end in the paths of the secrets.
+func Redacting(exporter sdktrace.SpanExporter) sdktrace.SpanExporter {
+	return redactingExporter{exporter}
+}
+
+type redactingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e redactingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, 0, len(spans))
+	for _, span := range spans {
+		redacted = append(redacted, redactedSpan{ReadOnlySpan: span})
+	}
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+// redactedSpan is a span whose URL attributes are redacted.
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue {
+	attributes := s.ReadOnlySpan.Attributes()
+	redacted := make([]attribute.KeyValue, 0, len(attributes))
+	for _, kv := range attributes {
+		for _, key := range urlKeys {
+			if kv.Key == key {
+				kv = key.String(logging.RedactURL(kv.Value.AsString()))
+			}
+		}
+		redacted = append(redacted, kv)
+	}
+	return redacted
+}
+
+// Middleware starts a span for every request, named after its route. Probes and scrapes are not traced.
+func Middleware() gin.HandlerFunc {
+	return otelgin.Middleware(serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/v1/healthz", "/v1/livez", "/v1/readyz", "/metrics":
+			return false
+		}
+		return true
+	}))
+}
+
+// InstrumentVault returns a function wrapping the transport of the Vault client of a cluster,
+// which starts a span for every request, a child of the span of the crawl or handler which made it.
+func InstrumentVault(cluster string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(next,
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return "vault " + r.Method + " " + metrics.Endpoint(r.URL.Path)
+			}),
+			otelhttp.WithSpanOptions(trace.WithAttributes(attribute.String("vault.cluster", cluster))),
+		)
+	}
+}
+
+// Start starts a span, a child of the span of the context if there is one.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End records the error, if there is one, and ends the span. URLs in the error are redacted like in the logs.
+func End(span trace.Span, err error) {
+	if err != nil {
+		message := logging.Error(err).Value.String()
+		span.AddEvent(semconv.ExceptionEventName, trace.WithAttributes(
+			semconv.ExceptionType(fmt.Sprintf("%T", err)),
+			semconv.ExceptionMessage(message),
+		))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
+
+// Folder returns the attributes of a folder of a mount, its depth is the number of segments of its path.
+// The path is redacted like in the logs.
+func Folder(mount, path string) []attribute.KeyValue {
+	depth := 0
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		depth = strings.Count(trimmed, "/") + 1
+	}
+	return []attribute.KeyValue{
+		attribute.String("vault.mount", mount),
+		attribute.String("vault.path", logging.Redact(path)),
+		attribute.Int("vault.path.depth", depth),
+	}
+}
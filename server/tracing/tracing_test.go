@@ -0,0 +1,132 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"secretpaths/logging"
+	"secretpaths/tracing"
+	"strings"
+	"testing"
+)
+
+// provider is shared by the tests, the tracer of the package stays bound to the first provider which is set.
+var provider = sdktrace.NewTracerProvider()
+
+// process registers the processor with the provider until the test ends.
+func process(t *testing.T, processor sdktrace.SpanProcessor) {
+	otel.SetTracerProvider(provider)
+	provider.RegisterSpanProcessor(processor)
+	t.Cleanup(func() { provider.UnregisterSpanProcessor(processor) })
+}
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	process(t, recorder)
+	return recorder
+}
+
+func TestInstrumentVault(t *testing.T) {
+	recorder := record(t)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer vault.Close()
+	client := &http.Client{Transport: tracing.InstrumentVault("traced")(http.DefaultTransport)}
+
+	ctx, crawl := tracing.Start(context.Background(), "crawl")
+	request, _ := http.NewRequestWithContext(ctx, "LIST", vault.URL+"/v1/secret/metadata/team-a/", nil)
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	tracing.End(crawl, errors.New("vault unreachable"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected the request and the crawl to be recorded, got %d spans", len(spans))
+	}
+	sent, parent := spans[0], spans[1]
+	if sent.Name() != "vault LIST secret/metadata" {
+		t.Errorf("expected the span to be named after the endpoint, got %q", sent.Name())
+	}
+	if sent.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected the request to be a child of the crawl")
+	}
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range sent.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	if attributes["vault.cluster"].AsString() != "traced" || attributes["http.status_code"].AsInt64() != 404 {
+		t.Errorf("expected the cluster and the status code, got %v", sent.Attributes())
+	}
+	if parent.Status().Code != codes.Error {
+		t.Errorf("expected the error of the crawl to be recorded, got %v", parent.Status())
+	}
+}
+
+func TestFolder(t *testing.T) {
+	for path, expected := range map[string]int64{"/": 0, "/team-a/": 1, "/team-a/db/": 2} {
+		for _, kv := range tracing.Folder("secret", path) {
+			if kv.Key == "vault.path.depth" && kv.Value.AsInt64() != expected {
+				t.Errorf("%s: expected depth %d, got %d", path, expected, kv.Value.AsInt64())
+			}
+		}
+	}
+}
+
+func TestRedacting(t *testing.T) {
+	if err := logging.Setup(&bytes.Buffer{}, "text", "info", logging.PathsRedact); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = logging.Setup(&bytes.Buffer{}, "text", "info", logging.PathsPlain)
+	})
+	exporter := tracetest.NewInMemoryExporter()
+	process(t, sdktrace.NewSimpleSpanProcessor(tracing.Redacting(exporter)))
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer vault.Close()
+	client := &http.Client{Transport: tracing.InstrumentVault("traced")(http.DefaultTransport)}
+
+	ctx, folder := tracing.Start(context.Background(), "list folder", tracing.Folder("secret", "/team-a/db/")...)
+	address := vault.URL + "/v1/secret/metadata/team-a/db?list=true"
+	request, _ := http.NewRequestWithContext(ctx, "LIST", address, nil)
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	tracing.End(folder, &url.Error{Op: "Get", URL: address, Err: errors.New("connection reset")})
+
+	for _, span := range exporter.GetSpans() {
+		for _, kv := range span.Attributes {
+			if strings.Contains(kv.Value.Emit(), "team-a") {
+				t.Errorf("%s: expected the path to be redacted, got %s=%s", span.Name, kv.Key, kv.Value.Emit())
+			}
+		}
+		for _, event := range span.Events {
+			for _, kv := range event.Attributes {
+				if strings.Contains(kv.Value.Emit(), "team-a") {
+					t.Errorf("%s: expected the path to be redacted in the event, got %s", span.Name, kv.Value.Emit())
+				}
+			}
+		}
+		if strings.Contains(span.Status.Description, "team-a") {
+			t.Errorf("%s: expected the path to be redacted in the status, got %s", span.Name, span.Status.Description)
+		}
+	}
+	if spans := exporter.GetSpans(); len(spans) != 2 || spans[1].Status.Code != codes.Error {
+		t.Errorf("expected the request and the failed folder, got %+v", spans)
+	}
+}
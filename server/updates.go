@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"secretpaths/events"
+	"secretpaths/logging"
+	"secretpaths/metrics"
+	"secretpaths/models"
+	"slices"
+	"time"
+)
+
+// watchEvents applies the events of every cluster which has them enabled to its inventory until ctx is done.
+// The scheduled crawls go on, they pick up what the events do not cover.
+func (r *refresher) watchEvents(ctx context.Context) {
+	for _, target := range r.clusters {
+		if target.settings.Events {
+			go r.watch(logging.With(ctx, "cluster", target.name), target, events.NewVault(target.manager))
+		}
+	}
+}
+
+// watch applies the events of the source to the inventory of the cluster until ctx is done. Once the source
+// subscribed again after it lost the subscription, the cluster is crawled to catch up with the events it missed.
+func (r *refresher) watch(ctx context.Context, target *cluster, source events.Source) {
+	subscriptions := 0
+	events.Watch(ctx, source, func(event events.Event) {
+		if event.Kind != events.Subscribed {
+			applyEvent(ctx, target, event)
+			return
+		}
+		if subscriptions++; subscriptions > 1 {
+			logging.FromContext(ctx).Info("subscribed to the events again, crawling the cluster to catch up")
+			r.start("events", []*cluster{target})
+		}
+	})
+}
+
+// applyEvent updates the inventory of the cluster with a change of a secret.
+func applyEvent(ctx context.Context, target *cluster, event events.Event) {
+	logger := logging.FromContext(ctx)
+	switch event.Kind {
+	case events.SecretWritten:
+		if event.Mount != target.settings.KVEngine {
+			return
+		}
+		secret := models.Secret{Path: event.Path, Mount: event.Mount, Cluster: target.name}
+		var updated *time.Time
+		if target.settings.ReadMetadata {
+			updated = &event.Time
+		}
+		target.change(func(inventory snapshot) snapshot {
+			return inventory.withSecret(ctx, secret, updated)
+		})
+		logger.Debug("applied an event", "type", event.Type, logging.Path(event.Path))
+	case events.SecretDeleted:
+		if event.Mount != target.settings.KVEngine {
+			return
+		}
+		target.change(func(inventory snapshot) snapshot {
+			return inventory.withoutSecret(ctx, event.Path)
+		})
+		logger.Debug("applied an event", "type", event.Type, logging.Path(event.Path))
+	default:
+		return
+	}
+	metrics.ObserveEvent(target.name, string(event.Kind))
+}
+
+// change applies an update to the inventory of the cluster once it was crawled. While a crawl runs, the update
+// is also kept to be applied to its result.
+func (c *cluster) change(update func(snapshot) snapshot) {
+	c.changes.Lock()
+	defer c.changes.Unlock()
+	if c.crawls > 0 {
+		c.missed = append(c.missed, update)
+	}
+	if inventory := c.inventory.Load(); inventory != nil {
+		updated := update(*inventory)
+		c.inventory.Store(&updated)
+		c.publish(inventory, &updated, causeEvent)
+	}
+}
+
+// crawlStarted records that a crawl of the cluster began, the updates from now on are applied to its result.
+func (c *cluster) crawlStarted() {
+	c.changes.Lock()
+	defer c.changes.Unlock()
+	c.crawls++
+}
+
+// crawlFinished stores the result of a crawl, nil if it failed, with the updates made while it ran applied to it.
+func (c *cluster) crawlFinished(result *snapshot) {
+	c.changes.Lock()
+	defer c.changes.Unlock()
+	c.crawls--
+	if result != nil {
+		for _, update := range c.missed {
+			updated := update(*result)
+			result = &updated
+		}
+		c.publish(c.inventory.Swap(result), result, causeCrawl)
+	}
+	if c.crawls == 0 {
+		c.missed = nil
+	}
+}
+
+// withSecret returns a copy of the inventory with the secret, annotated with the policies of the inventory.
+// If the secret is known already, only the time it was last written is updated if it is set.
+// The inventory shares everything that did not change, so it must never be modified in place.
+func (s snapshot) withSecret(ctx context.Context, secret models.Secret, updated *time.Time) snapshot {
+	if _, ok := s.policies[secret.Path]; ok {
+		if updated == nil {
+			return s
+		}
+		return s.withGraph(ctx, s.graph.Put(secret, updated))
+	}
+	annotated := models.AnnotatedSecret{Path: secret, Policies: accessibleBy(secret.Path, s.acl)}
+	s.secrets = append(slices.Clip(s.secrets), annotated)
+	s.paths = append(slices.Clip(s.paths), secret)
+	s.policies = maps.Clone(s.policies)
+	s.policies[secret.Path] = policyNames([]models.AnnotatedSecret{annotated})[secret.Path]
+	return s.withGraph(ctx, s.graph.Put(secret, updated))
+}
+
+// withoutSecret returns a copy of the inventory without the secret at path and the folders it leaves empty.
+func (s snapshot) withoutSecret(ctx context.Context, path string) snapshot {
+	if _, ok := s.policies[path]; !ok {
+		return s
+	}
+	s.secrets = slices.DeleteFunc(slices.Clone(s.secrets), func(secret models.AnnotatedSecret) bool {
+		return secret.Path.Path == path
+	})
+	s.paths = slices.DeleteFunc(slices.Clone(s.paths), func(secret models.Secret) bool {
+		return secret.Path == path
+	})
+	s.policies = maps.Clone(s.policies)
+	delete(s.policies, path)
+	return s.withGraph(ctx, s.graph)
+}
+
+// withGraph replaces the graph by a copy with only the secrets of the inventory and their aggregates.
+func (s snapshot) withGraph(ctx context.Context, graph models.GraphEntry) snapshot {
+	s.graph = graph.Restrict(s.secrets)
+	s.compressed = getCompressedGraph(ctx, s.graph)
+	return s
+}
+
+// accessibleBy returns the policies which grant access to the secret at path.
+func accessibleBy(path string, policies []models.Policy) []models.Policy {
+	var accessible []models.Policy
+	for _, policy := range policies {
+		if policy.HasAccessTo(path) {
+			accessible = append(accessible, policy)
+		}
+	}
+	return accessible
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"secretpaths/events"
+	"slices"
+	"testing"
+	"time"
+)
+
+// eventually fails the test unless condition holds within a few seconds, the events are applied asynchronously.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// watched returns a crawled cluster of the Vault, whose inventory is updated by the events of the fake source.
+func watched(t *testing.T, vault *fakeVault) (*cluster, *events.Fake, *refresher) {
+	target := newTestCluster("primary", vault)
+	crawl(t, target)
+	ctx, cancel := context.WithCancel(context.Background())
+	refresh := newRefresher(ctx, []*cluster{target})
+	source := events.NewFake()
+	go refresh.watch(ctx, target, source)
+	t.Cleanup(func() {
+		cancel()
+		_ = refresh.stop(context.Background())
+	})
+	return target, source, refresh
+}
+
+func TestWatch_Secrets(t *testing.T) {
+	target, source, _ := watched(t, newFakeVault(t))
+
+	source.Publish(events.Event{Kind: events.SecretWritten, Mount: "secret", Path: "/team-a/new"})
+	eventually(t, "the written secret to be added", func() bool {
+		_, ok := target.inventory.Load().policies["/team-a/new"]
+		return ok
+	})
+	inventory := target.inventory.Load()
+	if policies := inventory.policies["/team-a/new"]; !slices.Equal(policies, []string{"team-a"}) {
+		t.Errorf("expected: %v, got: %v", []string{"team-a"}, policies)
+	}
+	if len(inventory.secrets) != 4 || len(inventory.paths) != 4 {
+		t.Errorf("expected 4 secrets, got %d secrets and %d paths", len(inventory.secrets), len(inventory.paths))
+	}
+	if folder, ok := inventory.graph.Find("/team-a"); !ok || folder.Secrets != 3 || folder.Policies != 1 {
+		t.Errorf("expected /team-a to aggregate 3 secrets and 1 policy, got %+v", folder.Aggregates)
+	}
+	if inventory.compressed.Secrets != 4 {
+		t.Errorf("expected: %d, got: %d", 4, inventory.compressed.Secrets)
+	}
+
+	source.Publish(events.Event{Kind: events.SecretWritten, Mount: "other", Path: "/team-a/ignored"})
+	source.Publish(events.Event{Kind: events.SecretDeleted, Mount: "secret", Path: "/team-b/db"})
+	eventually(t, "the deleted secret to be removed", func() bool {
+		_, ok := target.inventory.Load().policies["/team-b/db"]
+		return !ok
+	})
+	inventory = target.inventory.Load()
+	if _, ok := inventory.policies["/team-a/ignored"]; ok {
+		t.Error("expected the secret of another mount to be ignored")
+	}
+	if _, ok := inventory.graph.Find("/team-b"); ok {
+		t.Error("expected the empty folder /team-b to be removed")
+	}
+	if inventory.graph.Secrets != 3 || len(inventory.secrets) != 3 {
+		t.Errorf("expected 3 secrets, got %d in the graph and %d annotated", inventory.graph.Secrets, len(inventory.secrets))
+	}
+}
+
+func TestWatch_DuringCrawl(t *testing.T) {
+	target, source, _ := watched(t, newFakeVault(t))
+	// the result of a crawl which listed the secrets before the event
+	result := *target.inventory.Load()
+
+	target.crawlStarted()
+	source.Publish(events.Event{Kind: events.SecretWritten, Mount: "secret", Path: "/team-b/new"})
+	eventually(t, "the written secret to be added", func() bool {
+		_, ok := target.inventory.Load().policies["/team-b/new"]
+		return ok
+	})
+	target.crawlFinished(&result)
+
+	inventory := target.inventory.Load()
+	if _, ok := inventory.policies["/team-b/new"]; !ok {
+		t.Fatal("expected the event to be applied to the result of the crawl")
+	}
+	if folder, ok := inventory.graph.Find("/team-b"); !ok || folder.Secrets != 2 {
+		t.Errorf("expected /team-b to aggregate 2 secrets, got %+v", folder.Aggregates)
+	}
+
+	// Vault does not have the secret, so the next crawl removes it, the event is not applied again
+	crawl(t, target)
+	if _, ok := target.inventory.Load().policies["/team-b/new"]; ok {
+		t.Error("expected the event to be applied only to the crawl it happened during")
+	}
+}
+
+func TestWatch_Resubscribed(t *testing.T) {
+	_, source, refresh := watched(t, newFakeVault(t))
+
+	source.Fail(errors.New("connection reset"))
+	eventually(t, "a crawl to catch up with the missed events", func() bool {
+		refresh.mu.Lock()
+		defer refresh.mu.Unlock()
+		return len(refresh.jobs) == 1 && refresh.jobs[0].Trigger == "events"
+	})
+}
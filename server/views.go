@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+	"net/http"
+	"secretpaths/apiauth"
+	"secretpaths/config"
+	"secretpaths/models"
+	"slices"
+	"sort"
+)
+
+// callerHeader carries the Vault token of the caller, the same header the Vault API uses.
+const callerHeader = apiauth.VaultTokenHeader
+
+// rootPolicy grants access to everything, Vault does not evaluate its rules.
+const rootPolicy = "root"
+
+// errUnauthenticated is returned if the caller did not send a token or Vault rejected it.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// caller is the identity behind a Vault token, as returned by auth/token/lookup-self.
+type caller struct {
+	DisplayName string   `json:"displayName"`
+	EntityId    string   `json:"entityId,omitempty"`
+	Policies    []string `json:"policies"`
+}
+
+// access is a secret the caller has access to with the capabilities their policies grant.
+type access struct {
+	models.Secret
+	Capabilities []string `json:"capabilities"`
+}
+
+func userViews(c *gin.Context) bool {
+	return c.MustGet("config").(config.Config).UserViews
+}
+
+// lookupCaller returns the caller of the request in the cluster. Lookups are cached by a hash of the token,
+// so a revoked token keeps its view until the cache expires.
+func lookupCaller(c *gin.Context, target *cluster) (caller, error) {
+	token := c.GetHeader(callerHeader)
+	if token == "" {
+		return caller{}, fmt.Errorf("%w: the %s header is required", errUnauthenticated, callerHeader)
+	}
+	return lookupToken(c, target, token)
+}
+
+// lookupToken returns the identity behind the token in the cluster.
+func lookupToken(ctx context.Context, target *cluster, token string) (caller, error) {
+	hash := sha256.Sum256([]byte(token))
+	key := "caller:" + hex.EncodeToString(hash[:])
+	if cached, ok := target.cache.Get(key); ok {
+		return cached.(caller), nil
+	}
+
+	client, err := target.manager.Client(ctx)
+	if err != nil {
+		return caller{}, err
+	}
+	resp, err := client.Auth.TokenLookUpSelf(ctx, vault.WithToken(token))
+	if err != nil {
+		var responseError *vault.ResponseError
+		if errors.As(err, &responseError) && responseError.StatusCode == http.StatusForbidden {
+			return caller{}, fmt.Errorf("%w: vault rejected the token", errUnauthenticated)
+		}
+		return caller{}, err
+	}
+	identity := caller{Policies: []string{}}
+	identity.DisplayName, _ = resp.Data["display_name"].(string)
+	identity.EntityId, _ = resp.Data["entity_id"].(string)
+	for _, field := range []string{"policies", "identity_policies"} {
+		policies, _ := resp.Data[field].([]interface{})
+		for _, policy := range policies {
+			if name, ok := policy.(string); ok && !slices.Contains(identity.Policies, name) {
+				identity.Policies = append(identity.Policies, name)
+			}
+		}
+	}
+	sort.Strings(identity.Policies)
+	target.cache.Set(key, identity)
+	return identity, nil
+}
+
+// canSee returns true if one of the policies of the caller grants access to the secret.
+func (c caller) canSee(secret models.AnnotatedSecret) bool {
+	names := make([]string, 0, len(secret.Policies))
+	for _, policy := range secret.Policies {
+		names = append(names, policy.Name)
+	}
+	return c.hasAny(names)
+}
+
+// hasAny returns true if the caller has the root policy or one of the named policies.
+func (c caller) hasAny(policies []string) bool {
+	if slices.Contains(c.Policies, rootPolicy) {
+		return true
+	}
+	for _, policy := range policies {
+		if slices.Contains(c.Policies, policy) {
+			return true
+		}
+	}
+	return false
+}
+
+// capabilities returns the capabilities the policies of the caller grant on the secret.
+func (c caller) capabilities(secret models.AnnotatedSecret) []string {
+	if slices.Contains(c.Policies, rootPolicy) {
+		return []string{rootPolicy}
+	}
+	capabilities := []string{}
+	for _, policy := range secret.Policies {
+		if !slices.Contains(c.Policies, policy.Name) {
+			continue
+		}
+		for _, capability := range policy.CapabilitiesFor(secret.Path.Path) {
+			if !slices.Contains(capabilities, capability) {
+				capabilities = append(capabilities, capability)
+			}
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// visibleSecrets returns the secrets of the cluster the caller may see, which are all secrets unless user views are enabled.
+func visibleSecrets(c *gin.Context, target *cluster) ([]models.AnnotatedSecret, error) {
+	secrets, err := cachedAnnotatedSecrets(c, target)
+	if err != nil || !userViews(c) {
+		return secrets, err
+	}
+	identity, err := lookupCaller(c, target)
+	if err != nil {
+		return nil, err
+	}
+	visible := []models.AnnotatedSecret{}
+	for _, secret := range secrets {
+		if identity.canSee(secret) {
+			visible = append(visible, secret)
+		}
+	}
+	return visible, nil
+}
+
+// visibleGraph returns the graph of the cluster restricted to the secrets the caller may see.
+func visibleGraph(c *gin.Context, target *cluster) (models.GraphEntry, error) {
+	graph, err := cachedGraph(c, target)
+	if err != nil || !userViews(c) {
+		return graph, err
+	}
+	secrets, err := visibleSecrets(c, target)
+	if err != nil {
+		return models.GraphEntry{}, err
+	}
+	return graph.Restrict(secrets), nil
+}
+
+// getAccess lists the secrets the caller has access to and the capabilities they have, answering "what can I access".
+func getAccess(c *gin.Context) {
+	target, ok := selectCluster(c)
+	if !ok {
+		return
+	}
+	identity, err := lookupCaller(c, target)
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	secrets, err := cachedAnnotatedSecrets(c, target)
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	accessible := []access{}
+	for _, secret := range secrets {
+		if identity.canSee(secret) {
+			accessible = append(accessible, access{Secret: secret.Path, Capabilities: identity.capabilities(secret)})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"cluster":     target.name,
+		"displayName": identity.DisplayName,
+		"entityId":    identity.EntityId,
+		"policies":    identity.Policies,
+		"secrets":     accessible,
+	})
+}
+
+// oidcLogin redirects the browser to the OIDC provider configured for the Vault OIDC auth method.
+func oidcLogin(c *gin.Context) {
+	target, ok := selectCluster(c)
+	if !ok {
+		return
+	}
+	login := target.settings.OIDCLogin
+	if login.Role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+	client, err := target.manager.Client(c)
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	resp, err := client.Auth.JwtOidcRequestAuthorizationUrl(c, schema.JwtOidcRequestAuthorizationUrlRequest{
+		Role:        login.Role,
+		RedirectUri: login.RedirectURI,
+	}, vault.WithMountPath(oidcMount(login)))
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	url, _ := resp.Data["auth_url"].(string)
+	if url == "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "vault did not return an authorization url, check the redirect uri of the role"})
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
+
+// oidcCallback completes the OIDC login and returns the Vault token of the caller,
+// which is sent in the X-Vault-Token header of further requests.
+func oidcCallback(c *gin.Context) {
+	target, ok := selectCluster(c)
+	if !ok {
+		return
+	}
+	login := target.settings.OIDCLogin
+	if login.Role == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+	client, err := target.manager.Client(c)
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	resp, err := client.Auth.JwtOidcCallback(c, "", c.Query("code"), c.Query("state"), vault.WithMountPath(oidcMount(login)))
+	if err != nil {
+		vaultError(c, err)
+		return
+	}
+	if resp.Auth == nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "vault did not return a token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":         resp.Auth.ClientToken,
+		"leaseDuration": resp.Auth.LeaseDuration,
+		"policies":      resp.Auth.Policies,
+	})
+}
+
+func oidcMount(login config.OIDCLogin) string {
+	if login.Mount == "" {
+		return "oidc"
+	}
+	return login.Mount
+}
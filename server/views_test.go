@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"secretpaths/config"
+	"secretpaths/models"
+	"slices"
+	"testing"
+)
+
+func userViewsServer(t *testing.T) (*fakeVault, string) {
+	vault := newFakeVault(t)
+	target := newTestCluster("primary", vault)
+	crawl(t, target)
+	server := newTestServer(t, []*cluster{target}, func(settings *config.Config) {
+		settings.UserViews = true
+		settings.API.AnonymousRole = "auditor"
+	})
+	return vault, server.URL
+}
+
+func TestUserViews_Secrets(t *testing.T) {
+	_, url := userViewsServer(t)
+	tests := map[string][]string{
+		"s.alice": {"/team-a/api/key", "/team-a/db"},
+		"s.bob":   {"/team-b/db"},
+	}
+	for token, expected := range tests {
+		t.Run(token, func(t *testing.T) {
+			var paths []models.Secret
+			if response := call(t, http.MethodGet, url+"/v1/paths", &paths, callerHeader, token); response.StatusCode != http.StatusOK {
+				t.Fatalf("expected: %d, got: %d", http.StatusOK, response.StatusCode)
+			}
+			var visible []string
+			for _, path := range paths {
+				visible = append(visible, path.Path)
+			}
+			slices.Sort(visible)
+			if !slices.Equal(visible, expected) {
+				t.Errorf("expected: %v, got: %v", expected, visible)
+			}
+
+			var annotated []models.AnnotatedSecret
+			call(t, http.MethodGet, url+"/v1/annotatedSecrets", &annotated, callerHeader, token)
+			if len(annotated) != len(expected) {
+				t.Errorf("expected %d annotated secrets, got %+v", len(expected), annotated)
+			}
+		})
+	}
+}
+
+func TestUserViews_Graph(t *testing.T) {
+	_, url := userViewsServer(t)
+	var root models.GraphEntry
+	if response := call(t, http.MethodGet, url+"/v1/graph/children?path=/&depth=3", &root, callerHeader, "s.alice"); response.StatusCode != http.StatusOK {
+		t.Fatalf("expected: %d, got: %d", http.StatusOK, response.StatusCode)
+	}
+	if len(root.Children) != 1 || root.Children[0].Name != "team-a" {
+		t.Fatalf("expected only the folder of team-a, got %+v", root.Children)
+	}
+	if root.Secrets != 2 || root.Children[0].Secrets != 2 {
+		t.Errorf("expected the aggregates to only count the visible secrets, got %d and %d", root.Secrets, root.Children[0].Secrets)
+	}
+	if _, ok := root.Find("/team-b/db"); ok {
+		t.Error("expected /team-b/db to be hidden")
+	}
+	if response := call(t, http.MethodGet, url+"/v1/graph/children?path=/team-b", nil, callerHeader, "s.alice"); response.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the folder of team-b to be hidden, got %d", response.StatusCode)
+	}
+}
+
+func TestUserViews_Unauthenticated(t *testing.T) {
+	vault, url := userViewsServer(t)
+	for name, headers := range map[string][]string{
+		"no token":       nil,
+		"rejected token": {callerHeader, "s.mallory"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if response := call(t, http.MethodGet, url+"/v1/paths", nil, headers...); response.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected: %d, got: %d", http.StatusUnauthorized, response.StatusCode)
+			}
+		})
+	}
+
+	t.Run("failing lookup", func(t *testing.T) {
+		vault.change(func(vault *fakeVault) {
+			vault.brokenLookups = true
+		})
+		for _, endpoint := range []string{"/v1/paths", "/v1/annotatedSecrets", "/v1/graph", "/v1/graph/children"} {
+			var secrets any
+			response := call(t, http.MethodGet, url+endpoint, &secrets, callerHeader, "s.bob")
+			if response.StatusCode != http.StatusBadGateway || secrets != nil {
+				t.Errorf("expected %s to fail rather than return the unfiltered view, got %d with %v", endpoint, response.StatusCode, secrets)
+			}
+		}
+	})
+}
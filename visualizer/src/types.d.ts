@@ -10,6 +10,8 @@ export interface Policy {
 
 export interface Path {
 	path: string;
+	mount: string;
+	cluster?: string;
 }
 
 export interface AnnotatedSecret {
@@ -25,13 +27,53 @@ export interface GraphEntry {
 	children: GraphEntry[];
 }
 
-export interface CompressedGraphEntry {
+export interface Aggregates {
+	secrets: number;
+	policies: number;
+	maxCapability?: string;
+	oldestUpdate?: string;
+}
+
+export interface CompressedGraphEntry extends Aggregates {
 	prefix: string;
 	children?: CompressedGraphEntry[];
 }
 
+export interface GraphNode extends Aggregates {
+	path: string;
+	id: string;
+	name: string;
+	folder: boolean;
+	hasChildren: boolean;
+	children?: GraphNode[];
+}
+
+export interface Cluster {
+	name: string;
+	vaultAddress: string;
+	kvEngine: string;
+}
+
 export interface Information {
 	version: string;
 	vaultAddress: string;
 	kvEngine: string;
+	clusters: Cluster[];
+}
+
+export interface ChangedSecret {
+	path: string;
+	policies?: string[];
+	previous?: string[];
+}
+
+export interface Change {
+	id: number;
+	cluster: string;
+	mount: string;
+	cause: 'crawl' | 'event';
+	time: string;
+	added?: ChangedSecret[];
+	removed?: ChangedSecret[];
+	access?: ChangedSecret[];
 }